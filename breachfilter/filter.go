@@ -0,0 +1,155 @@
+// Package breachfilter is a bloom filter of SHA-1 password hashes, built
+// from a Have I Been Pwned style dump, for screening passwords offline.
+//
+// The hashes are already uniformly distributed, so the k bit positions of
+// a hash are derived from its own bytes by double hashing instead of
+// hashing it again.
+package breachfilter
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// fileMagic starts every filter file, followed by a format version.
+const (
+	fileMagic   = "GABF"
+	fileVersion = 1
+)
+
+var ErrInvalidFile = errors.New("not a breached password filter file")
+
+type Filter struct {
+	bits  []uint64
+	m     uint64
+	k     uint32
+	count uint64
+}
+
+// New sizes a filter for n hashes with a false positive rate of p.
+func New(n uint64, p float64) (*Filter, error) {
+	if n == 0 || p <= 0 || p >= 1 {
+		return nil, errors.New("filter needs a positive capacity and a false positive rate between 0 and 1")
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	m = (m + 63) / 64 * 64
+	return &Filter{bits: make([]uint64, m/64), m: m, k: k}, nil
+}
+
+// Add puts a SHA-1 digest into the filter.
+func (f *Filter) Add(digest [sha1.Size]byte) {
+	h1, h2 := split(digest)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// Contains reports whether digest may have been added. False positives
+// happen at the rate the filter was sized for; false negatives never do.
+func (f *Filter) Contains(digest [sha1.Size]byte) bool {
+	h1, h2 := split(digest)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsPassword hashes password with SHA-1 and checks the filter.
+func (f *Filter) ContainsPassword(password string) bool {
+	return f.Contains(sha1.Sum([]byte(password)))
+}
+
+// Count is the number of hashes added.
+func (f *Filter) Count() uint64 {
+	return f.count
+}
+
+// SizeBytes is the memory the bit array takes.
+func (f *Filter) SizeBytes() uint64 {
+	return f.m / 8
+}
+
+func split(digest [sha1.Size]byte) (uint64, uint64) {
+	h1 := binary.LittleEndian.Uint64(digest[0:8])
+	// an odd step never cycles back to h1 early
+	h2 := binary.LittleEndian.Uint64(digest[8:16]) | 1
+	return h1, h2
+}
+
+// WriteTo writes the filter as the magic, the version, k, m and the number
+// of hashes, followed by the bit array, all little endian.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, 25)
+	header = append(header, fileMagic...)
+	header = append(header, fileVersion)
+	header = binary.LittleEndian.AppendUint32(header, f.k)
+	header = binary.LittleEndian.AppendUint64(header, f.m)
+	header = binary.LittleEndian.AppendUint64(header, f.count)
+	written, err := bw.Write(header)
+	if err != nil {
+		return int64(written), err
+	}
+
+	word := make([]byte, 8)
+	for _, bits := range f.bits {
+		binary.LittleEndian.PutUint64(word, bits)
+		n, err := bw.Write(word)
+		written += n
+		if err != nil {
+			return int64(written), err
+		}
+	}
+	return int64(written), bw.Flush()
+}
+
+// Load reads a filter written by WriteTo into memory.
+func Load(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 1<<20)
+
+	header := make([]byte, 25)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[0:4]) != fileMagic {
+		return nil, ErrInvalidFile
+	}
+	if header[4] != fileVersion {
+		return nil, fmt.Errorf("unsupported breached password filter version %d", header[4])
+	}
+	f := &Filter{
+		k:     binary.LittleEndian.Uint32(header[5:9]),
+		m:     binary.LittleEndian.Uint64(header[9:17]),
+		count: binary.LittleEndian.Uint64(header[17:25]),
+	}
+	if f.k == 0 || f.m == 0 || f.m%64 != 0 {
+		return nil, ErrInvalidFile
+	}
+	if info, err := file.Stat(); err == nil && uint64(info.Size()) != 25+f.m/8 {
+		return nil, ErrInvalidFile
+	}
+
+	f.bits = make([]uint64, f.m/64)
+	word := make([]byte, 8)
+	for i := range f.bits {
+		if _, err := io.ReadFull(r, word); err != nil {
+			return nil, ErrInvalidFile
+		}
+		f.bits[i] = binary.LittleEndian.Uint64(word)
+	}
+	return f, nil
+}
@@ -0,0 +1,175 @@
+package breachfilter
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestFilter(t *testing.T, passwords ...string) *Filter {
+	t.Helper()
+	filter, err := New(1000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range passwords {
+		filter.Add(sha1.Sum([]byte(password)))
+	}
+	return filter
+}
+
+func testPasswords(prefix string, n int) []string {
+	passwords := make([]string, n)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return passwords
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		n       uint64
+		p       float64
+		wantErr bool
+	}{
+		{n: 1000, p: 0.001},
+		{n: 1, p: 0.5},
+		{n: 0, p: 0.001, wantErr: true},
+		{n: 1000, p: 0, wantErr: true},
+		{n: 1000, p: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("n=%d p=%g", tt.n, tt.p), func(t *testing.T) {
+			filter, err := New(tt.n, tt.p)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("New succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filter.m == 0 || filter.m%64 != 0 || filter.k == 0 {
+				t.Errorf("filter m = %d, k = %d, want a positive multiple of 64 and k > 0", filter.m, filter.k)
+			}
+		})
+	}
+}
+
+func TestAddContains(t *testing.T) {
+	added := testPasswords("breached", 1000)
+	filter := newTestFilter(t, added...)
+
+	if filter.Count() != 1000 {
+		t.Errorf("Count = %d, want 1000", filter.Count())
+	}
+	for _, password := range added {
+		if !filter.ContainsPassword(password) {
+			t.Fatalf("ContainsPassword(%q) = false for an added password", password)
+		}
+	}
+
+	// sized for 0.1%, so 10000 other passwords give about 10 false positives
+	falsePositives := 0
+	for _, password := range testPasswords("unseen", 10000) {
+		if filter.ContainsPassword(password) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("%d of 10000 unseen passwords matched, want about 10", falsePositives)
+	}
+}
+
+func writeFilter(t *testing.T, filter *Filter) string {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := filter.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || uint64(n) != 25+filter.SizeBytes() {
+		t.Fatalf("WriteTo wrote %d bytes, reported %d, want %d", buf.Len(), n, 25+filter.SizeBytes())
+	}
+	path := filepath.Join(t.TempDir(), "breached.bf")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWriteToLoadRoundTrip(t *testing.T) {
+	added := testPasswords("breached", 500)
+	filter := newTestFilter(t, added...)
+
+	loaded, err := Load(writeFilter(t, filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.m != filter.m || loaded.k != filter.k || loaded.Count() != filter.Count() {
+		t.Errorf("loaded m = %d, k = %d, count = %d, want %d, %d, %d",
+			loaded.m, loaded.k, loaded.Count(), filter.m, filter.k, filter.Count())
+	}
+	if !slices.Equal(loaded.bits, filter.bits) {
+		t.Error("loaded bits differ from the written ones")
+	}
+	for _, password := range added {
+		if !loaded.ContainsPassword(password) {
+			t.Fatalf("loaded filter lost %q", password)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	valid, err := os.ReadFile(writeFilter(t, newTestFilter(t, "password")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := func(change func([]byte) []byte) []byte {
+		return change(slices.Clone(valid))
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "empty", data: nil},
+		{name: "bad magic", data: modified(func(b []byte) []byte { copy(b, "GZIP"); return b })},
+		{name: "truncated header", data: valid[:20]},
+		{name: "truncated bits", data: valid[:len(valid)-8]},
+		{name: "trailing bytes", data: append(slices.Clone(valid), 0)},
+		{name: "zero k", data: modified(func(b []byte) []byte { copy(b[5:9], []byte{0, 0, 0, 0}); return b })},
+		{name: "m not a multiple of 64", data: modified(func(b []byte) []byte { b[9]++; return b })},
+		{name: "other version", data: modified(func(b []byte) []byte { b[4] = 2; return b }), wantErr: "unsupported breached password filter version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "breached.bf")
+			if err := os.WriteFile(path, tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidFile) {
+				t.Errorf("Load error = %v, want ErrInvalidFile", err)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.bf")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load of a missing file error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Command breachfilter builds the breached password filter read through
+// BREACHED_PASSWORD_FILTER from a Have I Been Pwned SHA-1 dump, whose lines
+// are an uppercase hex SHA-1 hash, a colon and how often it was seen.
+//
+//	go run ./cmd/breachfilter -in pwned-passwords-sha1.txt -out breached.bf
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go-auth/breachfilter"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	in := flag.String("in", "", "hash dump to read, - for stdin")
+	out := flag.String("out", "breached.bf", "filter file to write")
+	rate := flag.Float64("fp", 0.001, "false positive rate")
+	minCount := flag.Uint64("min-count", 1, "skip hashes seen fewer times than this")
+	capacity := flag.Uint64("n", 0, "number of hashes to size the filter for; counted from the input when 0")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *capacity == 0 {
+		if *in == "-" {
+			log.Fatal("-n is required when reading stdin")
+		}
+		n, err := scan(*in, *minCount, func([sha1.Size]byte) {})
+		if err != nil {
+			log.Fatal(err)
+		}
+		*capacity = n
+	}
+
+	filter, err := breachfilter.New(*capacity, *rate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := scan(*in, *minCount, filter.Add); err != nil {
+		log.Fatal(err)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := filter.WriteTo(file); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote %s: %d hashes, %.1f MiB\n", *out, filter.Count(), float64(filter.SizeBytes())/(1<<20))
+}
+
+// scan calls add for every hash in the dump seen at least minCount times.
+func scan(path string, minCount uint64, add func([sha1.Size]byte)) (uint64, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var n uint64
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		hash, count, hasCount := strings.Cut(text, ":")
+		if hasCount && minCount > 1 {
+			seen, err := strconv.ParseUint(count, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: invalid count %q", line, count)
+			}
+			if seen < minCount {
+				continue
+			}
+		}
+
+		var digest [sha1.Size]byte
+		if len(hash) != 2*sha1.Size {
+			return 0, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.Decode(digest[:], []byte(hash)); err != nil {
+			return 0, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		add(digest)
+		n++
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func hexSHA1(password string) string {
+	digest := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(digest[:]))
+}
+
+func writeDump(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScan(t *testing.T) {
+	path := writeDump(t,
+		hexSHA1("password")+":3861493",
+		"",
+		hexSHA1("letmein")+":2",
+		"  "+hexSHA1("rare one")+":1  ",
+		hexSHA1("no count"),
+	)
+
+	tests := []struct {
+		minCount uint64
+		want     []string
+	}{
+		{minCount: 1, want: []string{"password", "letmein", "rare one", "no count"}},
+		// lines without a count are kept, there is nothing to filter them by
+		{minCount: 2, want: []string{"password", "letmein", "no count"}},
+		{minCount: 1000, want: []string{"password", "no count"}},
+	}
+	for _, tt := range tests {
+		var got [][sha1.Size]byte
+		n, err := scan(path, tt.minCount, func(digest [sha1.Size]byte) { got = append(got, digest) })
+		if err != nil {
+			t.Fatalf("scan min-count %d: %v", tt.minCount, err)
+		}
+		if n != uint64(len(tt.want)) || len(got) != len(tt.want) {
+			t.Fatalf("scan min-count %d = %d hashes, added %d, want %d", tt.minCount, n, len(got), len(tt.want))
+		}
+		for i, password := range tt.want {
+			if got[i] != sha1.Sum([]byte(password)) {
+				t.Errorf("scan min-count %d hash %d is not %q", tt.minCount, i, password)
+			}
+		}
+	}
+}
+
+func TestScanInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantErr string
+	}{
+		{name: "short hash", line: "5BAA61E4:3", wantErr: "line 2: not a SHA-1 hash"},
+		{name: "not hex", line: strings.Repeat("Z", 40) + ":3", wantErr: "line 2: not a SHA-1 hash"},
+		{name: "bad count", line: hexSHA1("password") + ":many", wantErr: `line 2: invalid count "many"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeDump(t, hexSHA1("letmein")+":2", tt.line)
+			_, err := scan(path, 2, func([sha1.Size]byte) {})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("scan error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
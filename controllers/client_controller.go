@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"context"
+	"go-auth/models"
+	"go-auth/services"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ClientController struct {
+	clientservice services.ClientService
+}
+
+func NewClientController(clientservice services.ClientService) ClientController {
+	return ClientController{
+		clientservice: clientservice,
+	}
+}
+
+func (cc *ClientController) CreateClient(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var client models.Client
+	if err := c.ShouldBindJSON(&client); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(client); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	if client.Public && slices.Contains(client.GrantTypes, "client_credentials") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "public clients cannot use the client_credentials grant"})
+		return
+	}
+	client.Owner = c.GetString("uid")
+
+	secret, err := cc.clientservice.CreateClient(ctx, &client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if client.Public {
+		c.JSON(http.StatusCreated, gin.H{"client": client})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"client":        client,
+		"client_secret": secret,
+		"message":       "store the client secret now, it cannot be retrieved again",
+	})
+}
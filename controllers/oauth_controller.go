@@ -0,0 +1,806 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go-auth/helpers"
+	"go-auth/models"
+	"go-auth/services"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	authorizationCodeTTL = time.Minute
+	deviceCodeTTL        = 10 * time.Minute
+	deviceCodeInterval   = 5
+
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	grantTypeDeviceCode    = "urn:ietf:params:oauth:grant-type:device_code"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	// tokenTypeUserID identifies a user by user_id. RFC 8693 allows token
+	// types beyond the registered ones.
+	tokenTypeUserID = "urn:go-auth:params:oauth:token-type:user_id"
+)
+
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"}
+
+type OAuthController struct {
+	userservice   services.UserService
+	tokenservice  services.TokenService
+	clientservice services.ClientService
+	roleservice   services.RoleService
+}
+
+func NewOAuthController(userservice services.UserService, tokenservice services.TokenService, clientservice services.ClientService, roleservice services.RoleService) OAuthController {
+	return OAuthController{
+		userservice:   userservice,
+		tokenservice:  tokenservice,
+		clientservice: clientservice,
+		roleservice:   roleservice,
+	}
+}
+
+// Authorize is the authorization endpoint of the authorization code flow.
+// GET shows the hosted login page, POST checks the credentials with
+// UserService and redirects back to the client with a single use code.
+// PKCE with S256 is mandatory for every client.
+func (o *OAuthController) Authorize(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	form := c.Request.Form
+
+	// never redirect to an unregistered URI, answer directly instead
+	client, err := o.clientservice.GetClient(ctx, form.Get("client_id"))
+	if err != nil || !slices.Contains(client.RedirectURIs, form.Get("redirect_uri")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "unknown client_id or redirect_uri"})
+		return
+	}
+	redirectURI := form.Get("redirect_uri")
+	state := form.Get("state")
+
+	if form.Get("response_type") != "code" {
+		redirectWithParams(c, redirectURI, url.Values{"error": {"unsupported_response_type"}, "state": {state}})
+		return
+	}
+	if form.Get("code_challenge") == "" || form.Get("code_challenge_method") != "S256" {
+		redirectWithParams(c, redirectURI, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"PKCE with code_challenge_method S256 is required"},
+			"state":             {state},
+		})
+		return
+	}
+	scope := helpers.FilterScope(form.Get("scope"), helpers.SupportedUserScopes)
+
+	if c.Request.Method == http.MethodGet {
+		o.renderAuthorize(c, http.StatusOK, client, scope, "")
+		return
+	}
+
+	email, password := c.PostForm("email"), c.PostForm("password")
+	user, err := o.userservice.CheckCredentials(ctx, &email, &password, c.ClientIP())
+	var lockErr *services.LockoutError
+	if errors.As(err, &lockErr) {
+		sendUnlockEmail(c, email, lockErr)
+		o.renderAuthorize(c, http.StatusTooManyRequests, client, scope, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrPasswordChangeRequired) {
+		o.renderAuthorize(c, http.StatusForbidden, client, scope, err.Error())
+		return
+	}
+	if err != nil {
+		o.renderAuthorize(c, http.StatusUnauthorized, client, scope, "email or password is incorrect")
+		return
+	}
+
+	code := helpers.NewTokenID() + helpers.NewTokenID()
+	record := &models.AuthorizationCode{
+		ClientID:            client.ClientID,
+		UserID:              user.User_id,
+		RedirectURI:         redirectURI,
+		Scope:               scope,
+		Nonce:               form.Get("nonce"),
+		CodeChallenge:       form.Get("code_challenge"),
+		CodeChallengeMethod: "S256",
+		AuthTime:            time.Now(),
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	}
+	if err := o.tokenservice.SaveAuthorizationCode(ctx, record, code); err != nil {
+		redirectWithParams(c, redirectURI, url.Values{"error": {"server_error"}, "state": {state}})
+		return
+	}
+
+	redirectWithParams(c, redirectURI, url.Values{"code": {code}, "state": {state}})
+}
+
+func (o *OAuthController) renderAuthorize(c *gin.Context, status int, client *models.Client, scope string, message string) {
+	params := map[string]string{}
+	for _, name := range authorizeParams {
+		params[name] = c.Request.Form.Get(name)
+	}
+	data := struct {
+		ClientName string
+		Scope      string
+		Error      string
+		Action     string
+		Params     map[string]string
+	}{
+		ClientName: client.Name,
+		Scope:      scope,
+		Error:      message,
+		Action:     c.Request.URL.Path,
+		Params:     params,
+	}
+	renderPage(c, status, "template/authorize.html", data)
+}
+
+// renderPage renders a hosted page that must not be cached or framed.
+func renderPage(c *gin.Context, status int, file string, data any) {
+	t, err := template.ParseFiles(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error. Please try again later"})
+		return
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		log.Println("Error executing template:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error. Please try again later"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Data(status, "text/html; charset=utf-8", body.Bytes())
+}
+
+// DeviceAuthorization starts the RFC 8628 device flow for clients that
+// cannot open a browser themselves.
+func (o *OAuthController) DeviceAuthorization(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	client, err := o.tokenClient(ctx, c)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="`+helpers.Issuer()+`"`)
+		helpers.OAuthError(c, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+	if !clientAllowsGrant(client, grantTypeDeviceCode) {
+		helpers.OAuthError(c, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	deviceCode := helpers.NewTokenID() + helpers.NewTokenID()
+	record := &models.DeviceCode{
+		ClientID:  client.ClientID,
+		Scope:     helpers.FilterScope(c.PostForm("scope"), helpers.SupportedUserScopes),
+		Interval:  deviceCodeInterval,
+		ExpiresAt: time.Now().Add(deviceCodeTTL),
+	}
+	if err := o.tokenservice.SaveDeviceCode(ctx, record, deviceCode); err != nil {
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	verificationURI := helpers.PublicURL(c) + "/v1/oauth/device"
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 helpers.FormatUserCode(record.UserCode),
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + record.UserCode,
+		"expires_in":                int(deviceCodeTTL.Seconds()),
+		"interval":                  deviceCodeInterval,
+	})
+}
+
+// Device is the hosted page where a user enters the user code shown on
+// their device, sees which client asks for which scopes (RFC 8628 section
+// 5.4), and signs in to approve or deny it.
+func (o *OAuthController) Device(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	data := struct {
+		UserCode   string
+		ClientName string
+		Scopes     []string
+		Error      string
+		Message    string
+		Action     string
+	}{
+		UserCode: c.Query("user_code"),
+		Action:   c.Request.URL.Path,
+	}
+	describe := func() bool {
+		record, err := o.tokenservice.GetDeviceCodeByUserCode(ctx, helpers.NormalizeUserCode(data.UserCode))
+		if err != nil {
+			return false
+		}
+		client, err := o.clientservice.GetClient(ctx, record.ClientID)
+		if err != nil {
+			return false
+		}
+		data.ClientName = client.Name
+		data.Scopes = strings.Fields(record.Scope)
+		return true
+	}
+
+	if c.Request.Method == http.MethodGet {
+		status := http.StatusOK
+		if data.UserCode != "" && !describe() {
+			data.Error = services.ErrInvalidUserCode.Error()
+			status = http.StatusBadRequest
+		}
+		renderPage(c, status, "template/device.html", data)
+		return
+	}
+
+	data.UserCode = c.PostForm("user_code")
+	if !describe() {
+		data.Error = services.ErrInvalidUserCode.Error()
+		renderPage(c, http.StatusBadRequest, "template/device.html", data)
+		return
+	}
+	email, password := c.PostForm("email"), c.PostForm("password")
+	user, err := o.userservice.CheckCredentials(ctx, &email, &password, c.ClientIP())
+	var lockErr *services.LockoutError
+	if errors.As(err, &lockErr) {
+		sendUnlockEmail(c, email, lockErr)
+		data.Error = err.Error()
+		renderPage(c, http.StatusTooManyRequests, "template/device.html", data)
+		return
+	}
+	if errors.Is(err, services.ErrPasswordChangeRequired) {
+		data.Error = err.Error()
+		renderPage(c, http.StatusForbidden, "template/device.html", data)
+		return
+	}
+	if err != nil {
+		data.Error = "email or password is incorrect"
+		renderPage(c, http.StatusUnauthorized, "template/device.html", data)
+		return
+	}
+
+	approve := c.PostForm("decision") == "approve"
+	if err := o.tokenservice.DecideDeviceCode(ctx, helpers.NormalizeUserCode(data.UserCode), user.User_id, approve); err != nil {
+		data.Error = err.Error()
+		renderPage(c, http.StatusBadRequest, "template/device.html", data)
+		return
+	}
+
+	data.Message = "The device has been denied access."
+	if approve {
+		data.Message = "Your device is now signed in, you can close this page."
+	}
+	renderPage(c, http.StatusOK, "template/device.html", data)
+}
+
+func (o *OAuthController) deviceCodeGrant(ctx context.Context, c *gin.Context, client *models.Client, jkt string) {
+	record, err := o.tokenservice.PollDeviceCode(ctx, c.PostForm("device_code"))
+	switch err {
+	case nil:
+	case services.ErrAuthorizationPending, services.ErrSlowDown, services.ErrAccessDenied, services.ErrExpiredToken:
+		helpers.OAuthError(c, http.StatusBadRequest, err.Error(), "")
+		return
+	case services.ErrInvalidGrant:
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "")
+		return
+	default:
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if record.ClientID != client.ClientID {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "device code was issued to another client")
+		return
+	}
+
+	user, err := o.userservice.GetUser(ctx, &record.UserID)
+	if err != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+		return
+	}
+
+	tokens, err := o.userservice.IssueTokens(ctx, user, models.Grant{
+		ClientID:  client.ClientID,
+		Scope:     record.Scope,
+		AuthTime:  record.ApprovedAt,
+		FamilyID:  primitive.NewObjectID().Hex(),
+		Device:    deviceFromRequest(c),
+		JKT:       jkt,
+		Lifetimes: client.Lifetimes,
+	})
+	if errors.Is(err, services.ErrPasswordChangeRequired) {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	if err != nil {
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	tokenResponse(c, tokens)
+}
+
+// Token is the token endpoint. It redeems authorization codes and rotates
+// refresh tokens for registered clients.
+func (o *OAuthController) Token(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	client, err := o.tokenClient(ctx, c)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="`+helpers.Issuer()+`"`)
+		helpers.OAuthError(c, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	grantType := c.PostForm("grant_type")
+	if !clientAllowsGrant(client, grantType) {
+		helpers.OAuthError(c, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	// a proof for a token exchange also covers the actor token it presents
+	proofToken := ""
+	if grantType == grantTypeTokenExchange {
+		proofToken = c.PostForm("actor_token")
+	}
+	jkt, err := helpers.DPoPKey(c, proofToken)
+	if err != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_dpop_proof", "")
+		return
+	}
+	if client.RequireDPoP && jkt == "" {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_dpop_proof", "this client must send a DPoP proof")
+		return
+	}
+
+	switch grantType {
+	case grantTypeTokenExchange:
+		o.tokenExchangeGrant(ctx, c, client, jkt)
+	case "authorization_code":
+		o.authorizationCodeGrant(ctx, c, client, jkt)
+	case "refresh_token":
+		o.refreshTokenGrant(ctx, c, client, jkt)
+	case "client_credentials":
+		o.clientCredentialsGrant(c, client, jkt)
+	case grantTypeDeviceCode:
+		o.deviceCodeGrant(ctx, c, client, jkt)
+	default:
+		helpers.OAuthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// clientCredentialsGrant issues an access token to the client itself. No
+// refresh token is issued; the client simply asks again.
+func (o *OAuthController) clientCredentialsGrant(c *gin.Context, client *models.Client, jkt string) {
+	if client.Public {
+		helpers.OAuthError(c, http.StatusBadRequest, "unauthorized_client", "")
+		return
+	}
+
+	scope := strings.Join(client.AllowedScopes, " ")
+	if requested := c.PostForm("scope"); requested != "" {
+		scope = helpers.FilterScope(requested, client.AllowedScopes)
+		if len(strings.Fields(scope)) != len(strings.Fields(requested)) {
+			helpers.OAuthError(c, http.StatusBadRequest, "invalid_scope", "")
+			return
+		}
+	}
+
+	// machine clients have no user type, only the client override applies
+	ttl := helpers.ResolveLifetimes("", client.Lifetimes).Access
+	token, err := helpers.GenerateClientToken(client.ClientID, scope, jkt, ttl)
+	if err != nil {
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	tokens := &models.Tokens{TokenType: "Bearer", AccessToken: token, Scope: scope, ExpiresIn: int(ttl.Seconds())}
+	if jkt != "" {
+		tokens.TokenType = "DPoP"
+	}
+	tokenResponse(c, tokens)
+}
+
+// tokenExchangeGrant implements admin impersonation with RFC 8693 for an
+// authenticated client. The admin is the actor: actor_token is their access
+// token. subject_token is the user_id of the user to impersonate, typed
+// tokenTypeUserID. The issued token names the admin in its act claim.
+func (o *OAuthController) tokenExchangeGrant(ctx context.Context, c *gin.Context, client *models.Client, jkt string) {
+	if c.PostForm("subject_token_type") != tokenTypeUserID {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_request", "subject_token_type must be "+tokenTypeUserID)
+		return
+	}
+	if c.PostForm("actor_token_type") != tokenTypeAccessToken {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_request", "actor_token_type must be an access token")
+		return
+	}
+	if tokenType := c.PostForm("requested_token_type"); tokenType != "" && tokenType != tokenTypeAccessToken {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_request", "only access tokens can be requested")
+		return
+	}
+
+	claims, msg := helpers.ValidateToken(c.PostForm("actor_token"))
+	if msg != "" || claims.TokenType != "access" || claims.SubjectType == helpers.SubjectTypeClient {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "actor_token is invalid")
+		return
+	}
+	if revoked, err := o.tokenservice.IsRevoked(ctx, claims); err != nil || revoked {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "actor_token is invalid")
+		return
+	}
+	// a stolen sender-constrained token must not buy an unbound one, so the
+	// proof has to come from its key and the new token is bound to it too
+	if claims.Cnf != nil && claims.Cnf.JKT != jkt {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_dpop_proof", "actor_token is DPoP bound, send a proof from its key")
+		return
+	}
+	if claims.Act != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "impersonation tokens cannot be exchanged again")
+		return
+	}
+	if !slices.Contains(claims.Permissions, helpers.PermUsersImpersonate) {
+		helpers.OAuthError(c, http.StatusForbidden, "unauthorized_client", "missing permission "+helpers.PermUsersImpersonate)
+		return
+	}
+
+	targetId := c.PostForm("subject_token")
+	target, err := o.userservice.GetUser(ctx, &targetId)
+	if err != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "subject_token names no user")
+		return
+	}
+	if target.PasswordChangeRequired {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", services.ErrPasswordChangeRequired.Error())
+		return
+	}
+	permissions, err := o.roleservice.PermissionsFor(ctx, target.Roles)
+	if err != nil {
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if len(permissions) > 0 {
+		helpers.OAuthError(c, http.StatusForbidden, "invalid_target", "privileged users cannot be impersonated")
+		return
+	}
+
+	token, err := helpers.GenerateImpersonationToken(helpers.TokenParams{
+		Email:      *target.Email,
+		Username:   *target.Username,
+		UserType:   *target.User_type,
+		Uid:        target.User_id,
+		ClientID:   client.ClientID,
+		JKT:        jkt,
+		Roles:      target.Roles,
+		Attributes: target.Attributes,
+	}, helpers.Actor{Subject: claims.Subject, Email: claims.Email})
+	if err != nil {
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	log.Printf("audit: impersonation token issued actor=%s subject=%s client=%s ip=%s", claims.Subject, target.User_id, client.ClientID, c.ClientIP())
+
+	tokenType := "Bearer"
+	if jkt != "" {
+		tokenType = "DPoP"
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":      token,
+		"issued_token_type": tokenTypeAccessToken,
+		"token_type":        tokenType,
+		"expires_in":        int(helpers.ImpersonationTokenTTL.Seconds()),
+	})
+}
+
+// clientAllowsGrant treats clients registered without grant types as
+// interactive clients using the authorization code flow.
+func clientAllowsGrant(client *models.Client, grantType string) bool {
+	if len(client.GrantTypes) == 0 {
+		return grantType == "authorization_code" || grantType == "refresh_token"
+	}
+	return slices.Contains(client.GrantTypes, grantType)
+}
+
+func (o *OAuthController) authorizationCodeGrant(ctx context.Context, c *gin.Context, client *models.Client, jkt string) {
+	record, err := o.tokenservice.ConsumeAuthorizationCode(ctx, c.PostForm("code"))
+	if err != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	if record.ClientID != client.ClientID || record.RedirectURI != c.PostForm("redirect_uri") {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "code was issued to another client or redirect_uri")
+		return
+	}
+	if !helpers.VerifyPKCE(c.PostForm("code_verifier"), record.CodeChallenge) {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	user, err := o.userservice.GetUser(ctx, &record.UserID)
+	if err != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+		return
+	}
+
+	tokens, err := o.userservice.IssueTokens(ctx, user, models.Grant{
+		ClientID:  client.ClientID,
+		Scope:     record.Scope,
+		Nonce:     record.Nonce,
+		AuthTime:  record.AuthTime,
+		FamilyID:  record.FamilyID,
+		Device:    deviceFromRequest(c),
+		JKT:       jkt,
+		Lifetimes: client.Lifetimes,
+	})
+	if errors.Is(err, services.ErrPasswordChangeRequired) {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	if err != nil {
+		helpers.OAuthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	tokenResponse(c, tokens)
+}
+
+func (o *OAuthController) refreshTokenGrant(ctx context.Context, c *gin.Context, client *models.Client, jkt string) {
+	refreshToken := c.PostForm("refresh_token")
+	claims, msg := helpers.ValidateToken(refreshToken)
+	if msg != "" || claims.ClientID != client.ClientID {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+		return
+	}
+
+	tokens, err := o.userservice.Refresh(ctx, refreshToken, client, jkt, deviceFromRequest(c))
+	if err != nil {
+		helpers.OAuthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	tokenResponse(c, tokens)
+}
+
+// tokenClient authenticates confidential clients and identifies public
+// clients by their client_id.
+func (o *OAuthController) tokenClient(ctx context.Context, c *gin.Context) (*models.Client, error) {
+	if o.hasClientCredentials(c) {
+		return o.authenticateClient(ctx, c)
+	}
+	client, err := o.clientservice.GetClient(ctx, c.PostForm("client_id"))
+	if err != nil || !client.Public {
+		return nil, services.ErrInvalidClient
+	}
+	return client, nil
+}
+
+func tokenResponse(c *gin.Context, tokens *models.Tokens) {
+	body := gin.H{
+		"access_token": tokens.AccessToken,
+		"token_type":   tokens.TokenType,
+		"expires_in":   tokens.ExpiresIn,
+	}
+	if tokens.RefreshToken != "" {
+		body["refresh_token"] = tokens.RefreshToken
+	}
+	if tokens.IDToken != "" {
+		body["id_token"] = tokens.IDToken
+	}
+	if tokens.Scope != "" {
+		body["scope"] = tokens.Scope
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, body)
+}
+
+func redirectWithParams(c *gin.Context, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		if values[0] != "" {
+			query.Set(name, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// Introspect implements RFC 7662. Any token that fails a check is reported
+// as inactive without saying why.
+func (o *OAuthController) Introspect(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	if _, err := o.authenticateClient(ctx, c); err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="`+helpers.Issuer()+`"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	claims, msg := helpers.ValidateToken(token)
+	if msg != "" {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	tokenType := "Bearer"
+	switch claims.TokenType {
+	case "access":
+		revoked, err := o.tokenservice.IsRevoked(ctx, claims)
+		if err != nil || revoked {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+	case "refresh":
+		if _, err := o.tokenservice.GetRefreshToken(ctx, token); err != nil {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+		tokenType = "refresh_token"
+	default:
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	response := gin.H{
+		"active":     true,
+		"sub":        claims.Subject,
+		"scope":      claims.Scope,
+		"token_type": tokenType,
+		"exp":        claims.ExpiresAt.Unix(),
+		"iat":        claims.IssuedAt.Unix(),
+		"nbf":        claims.NotBefore.Unix(),
+		"iss":        claims.Issuer,
+		"aud":        claims.Audience,
+		"jti":        claims.ID,
+	}
+	if claims.ClientID != "" {
+		response["client_id"] = claims.ClientID
+	}
+	if claims.Act != nil {
+		response["act"] = claims.Act
+	}
+	if len(claims.Permissions) > 0 {
+		// resource servers authorize opaque tokens with these
+		response["roles"] = claims.Roles
+		response["permissions"] = claims.Permissions
+	}
+	if len(claims.Attributes) > 0 {
+		response["attrs"] = claims.Attributes
+	}
+	if claims.Cnf != nil {
+		response["cnf"] = claims.Cnf
+		response["token_type"] = "DPoP"
+	}
+
+	// the subject must still exist for the token to be active
+	if claims.SubjectType == helpers.SubjectTypeClient {
+		if _, err := o.clientservice.GetClient(ctx, claims.Subject); err != nil {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+	} else {
+		user, err := o.userservice.GetUser(ctx, &claims.Subject)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+		response["username"] = user.Username
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke implements RFC 7009. The response is always 200 for well formed
+// requests, whether or not the token was valid, so callers learn nothing
+// about tokens they present.
+func (o *OAuthController) Revoke(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	// public clients such as the mobile SDK have no secret; credentials are
+	// checked when they are sent and required for tokens of confidential
+	// clients below
+	var client *models.Client
+	if o.hasClientCredentials(c) {
+		authenticated, err := o.authenticateClient(ctx, c)
+		if err != nil {
+			revokeUnauthorized(c)
+			return
+		}
+		client = authenticated
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	// tokens are self describing, so token_type_hint is not needed to find them
+	claims, msg := helpers.ValidateToken(token)
+	if msg != "" {
+		c.Status(http.StatusOK)
+		return
+	}
+	if claims.ClientID != "" && (client == nil || client.ClientID != claims.ClientID) {
+		// only the client a token was issued to may revoke it, so tokens of
+		// confidential clients need that client's credentials (RFC 7009
+		// section 2.1)
+		owner, err := o.clientservice.GetClient(ctx, claims.ClientID)
+		if err != nil || !owner.Public {
+			revokeUnauthorized(c)
+			return
+		}
+		if client != nil {
+			c.Status(http.StatusOK)
+			return
+		}
+	}
+
+	var err error
+	switch claims.TokenType {
+	case "access":
+		err = o.tokenservice.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time, "revoked by client")
+	case "refresh":
+		err = o.tokenservice.RevokeRefreshToken(ctx, token)
+		if err == services.ErrRefreshTokenInvalid {
+			err = nil
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func revokeUnauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Basic realm="`+helpers.Issuer()+`"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+}
+
+func (o *OAuthController) hasClientCredentials(c *gin.Context) bool {
+	_, _, ok := c.Request.BasicAuth()
+	return ok || c.PostForm("client_secret") != ""
+}
+
+// authenticateClient accepts client credentials via HTTP Basic or the
+// client_id and client_secret form parameters.
+func (o *OAuthController) authenticateClient(ctx context.Context, c *gin.Context) (*models.Client, error) {
+	clientId, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientId = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+	return o.clientservice.AuthenticateClient(ctx, clientId, secret)
+}
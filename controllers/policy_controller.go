@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"go-auth/helpers"
+	"go-auth/services"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PolicyController struct {
+	policyservice services.PolicyService
+	userservice   services.UserService
+}
+
+func NewPolicyController(policyservice services.PolicyService, userservice services.UserService) PolicyController {
+	return PolicyController{
+		policyservice: policyservice,
+		userservice:   userservice,
+	}
+}
+
+func (pc *PolicyController) GetPolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"policies": pc.policyservice.GetPolicies()})
+}
+
+// Explain makes a dry-run decision and reports how every applicable policy
+// evaluated. The subject and request default to the caller and this
+// request; fields given in the body override them, so that decisions for
+// other subjects or times can be replayed.
+func (pc *PolicyController) Explain(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var req struct {
+		Action  string         `json:"action" validate:"required"`
+		UserID  string         `json:"user_id"`
+		Subject map[string]any `json:"subject"`
+		Request map[string]any `json:"request"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(req); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+	if !helpers.IsKnownPermission(req.Action) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown action " + req.Action})
+		return
+	}
+
+	var resource map[string]any
+	if req.UserID != "" {
+		// the input echoes the attributes of the user, so the caller must
+		// be allowed to read them
+		target, err := pc.userservice.GetUser(ctx, &req.UserID)
+		if err == nil {
+			resource = helpers.PolicyResource(target)
+		}
+		decision := pc.policyservice.Authorize(helpers.NewPolicyInput(c, helpers.PermUsersRead, resource))
+		if !decision.Allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource", "reason": decision.Reason})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+	}
+
+	input := helpers.NewPolicyInput(c, req.Action, resource)
+	maps.Copy(input.Subject, req.Subject)
+	maps.Copy(input.Request, req.Request)
+
+	c.JSON(http.StatusOK, gin.H{
+		"decision": pc.policyservice.Authorize(input),
+		"input":    input,
+	})
+}
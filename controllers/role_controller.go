@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"context"
+	"go-auth/helpers"
+	"go-auth/models"
+	"go-auth/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	roleservice services.RoleService
+}
+
+func NewRoleController(roleservice services.RoleService) RoleController {
+	return RoleController{
+		roleservice: roleservice,
+	}
+}
+
+func (rc *RoleController) GetRoles(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	roles, err := rc.roleservice.GetRoles(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (rc *RoleController) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"permissions": helpers.AllPermissions})
+}
+
+func (rc *RoleController) CreateRole(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var role models.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(role); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	err := rc.roleservice.CreateRole(ctx, &role)
+	if err == services.ErrRoleExists {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"role": role})
+}
+
+func (rc *RoleController) UpdateRole(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var role models.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := rc.roleservice.UpdateRole(ctx, c.Param("name"), &role)
+	if err == services.ErrRoleNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "role has been updated"})
+}
+
+func (rc *RoleController) DeleteRole(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	err := rc.roleservice.DeleteRole(ctx, c.Param("name"))
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"message": "role has been deleted"})
+	case services.ErrRoleNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case services.ErrSystemRole:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// AssignRoles replaces the roles of a user.
+func (rc *RoleController) AssignRoles(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var req struct {
+		Roles []string `json:"roles" validate:"required,min=1,dive,required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(req); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	userId := c.Param("user_id")
+	if userId == c.GetString("uid") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot change your own roles"})
+		return
+	}
+
+	err := rc.roleservice.AssignRoles(ctx, userId, req.Roles)
+	if err == services.ErrRoleNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "roles have been assigned", "roles": req.Roles})
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"go-auth/helpers"
 	"go-auth/models"
@@ -11,6 +12,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"time"
@@ -25,12 +27,18 @@ import (
 var validate = validator.New()
 
 type UserController struct {
-	userservice services.UserService
+	userservice    services.UserService
+	tokenservice   services.TokenService
+	policyservice  services.PolicyService
+	lockoutservice services.LockoutService
 }
 
-func NewUserController(userservice services.UserService) UserController {
+func NewUserController(userservice services.UserService, tokenservice services.TokenService, policyservice services.PolicyService, lockoutservice services.LockoutService) UserController {
 	return UserController{
-		userservice: userservice,
+		userservice:    userservice,
+		tokenservice:   tokenservice,
+		policyservice:  policyservice,
+		lockoutservice: lockoutservice,
 	}
 }
 
@@ -51,6 +59,9 @@ func (u *UserController) Signup(c *gin.Context) {
 	}
 
 	if err := u.userservice.Signup(ctx, &user); err != nil {
+		if passwordPolicyFailed(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -65,12 +76,37 @@ func (u *UserController) Login(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 	defer cancel()
 
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	token, refreshToken, foundUser, err := u.userservice.Login(ctx, user.Email, user.Password)
+	if validationErr := validate.Struct(req); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+	req.Device = deviceFromRequest(c)
+
+	jkt, err := helpers.DPoPKey(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.JKT = jkt
+	req.IP = c.ClientIP()
+
+	tokens, foundUser, err := u.userservice.Login(ctx, &req)
+	if lockedOut(c, *req.Email, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidLoginClient) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrPasswordChangeRequired) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "password_change_required": true})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -78,32 +114,108 @@ func (u *UserController) Login(c *gin.Context) {
 
 	c.SetCookie(
 		"refresh_token",
-		refreshToken,
-		3600*24*7,
+		tokens.RefreshToken,
+		tokens.RefreshExpiresIn,
 		"/",
 		os.Getenv("COOKIE_DOMAIN"),
 		true,
 		true,
 	)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":       "login successful",
-		"token":         token,
-		"refresh_token": refreshToken,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
 		"user":          foundUser,
-	})
+	}
+	if tokens.IDToken != "" {
+		response["id_token"] = tokens.IDToken
+		response["scope"] = tokens.Scope
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func (u *UserController) GetAll(c *gin.Context) {
+// lockedOut answers 423 or 429 when err is a LockoutError, mailing the
+// unlock link to email if this failure locked the account.
+func lockedOut(c *gin.Context, email string, err error) bool {
+	var lockErr *services.LockoutError
+	if !errors.As(err, &lockErr) {
+		return false
+	}
+	sendUnlockEmail(c, email, lockErr)
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(lockErr.Until).Seconds())+1))
+	status := http.StatusTooManyRequests
+	if errors.Is(err, services.ErrAccountLocked) {
+		status = http.StatusLocked
+	}
+	c.JSON(status, gin.H{"error": err.Error(), "locked_until": lockErr.Until})
+	return true
+}
+
+// sendUnlockEmail mails the unlock link when a failed login has just locked
+// the account. Sending is best effort; the lock expires on its own. No link
+// is sent without PUBLIC_BASE_URL.
+func sendUnlockEmail(c *gin.Context, email string, lockErr *services.LockoutError) {
+	if lockErr.UnlockToken == "" {
+		return
+	}
+	log.Printf("audit: account locked email=%s until=%s ip=%s", email, lockErr.Until.Format(time.RFC3339), c.ClientIP())
+	base, err := helpers.EmailBaseURL()
+	if err != nil {
+		log.Println("not sending unlock email:", err)
+		return
+	}
+	link := base + "/v1/account/unlock?token=" + url.QueryEscape(lockErr.UnlockToken)
+	data := struct {
+		Link  string
+		Until string
+	}{
+		Link:  link,
+		Until: lockErr.Until.UTC().Format(time.RFC1123),
+	}
+	plainText := fmt.Sprintf("Your account has been locked after too many failed login attempts. Unlock it: %s", link)
+	if err := helpers.SendEmail(email, "Your account has been locked", plainText, "template/unlock_account.html", data); err != nil {
+		log.Println("error sending unlock email:", err)
+	}
+}
+
+// UnlockAccount lifts a lock with the link from the unlock email.
+func (u *UserController) UnlockAccount(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 	defer cancel()
 
-	userType := c.GetString("user_type")
+	if err := u.lockoutservice.UnlockWithToken(ctx, c.Query("token")); err != nil {
+		status := http.StatusInternalServerError
+		if err == services.ErrInvalidUnlockToken {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "account has been unlocked"})
+}
+
+// AdminUnlock lifts the lock of an account.
+func (u *UserController) AdminUnlock(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
 
-	if err := helpers.CheckUserType(userType, "ADMIN"); err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	userId := c.Param("user_id")
+	if err := u.lockoutservice.Unlock(ctx, userId); err != nil {
+		status := http.StatusInternalServerError
+		if err == services.ErrAccountNotLocked {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	log.Printf("audit: account unlocked user=%s by=%s", userId, c.GetString("uid"))
+	c.JSON(http.StatusOK, gin.H{"message": "account has been unlocked"})
+}
+
+func (u *UserController) GetAll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
 
 	recordPerPage, err := strconv.Atoi(c.DefaultQuery("recordPerPage", "10"))
 	if err != nil || recordPerPage < 1 {
@@ -130,14 +242,8 @@ func (u *UserController) GetUser(c *gin.Context) {
 	defer cancel()
 	userId := c.Param("user_id")
 
-	if err := helpers.MatchUserTypeToUid(c, userId); err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-		return
-	}
-
-	foundUser, err := u.userservice.GetUser(ctx, &userId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	foundUser, ok := u.authorizeUser(c, ctx, helpers.PermUsersRead, userId)
+	if !ok {
 		return
 	}
 	c.JSON(http.StatusOK, foundUser)
@@ -152,6 +258,16 @@ func (u *UserController) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if user == nil || user.Email == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+	if helpers.IsEmailMatch(c, *user.Email) != nil {
+		if err := helpers.CheckPermission(c, helpers.PermUsersWrite); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	if err := u.userservice.UpdateUser(ctx, user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -165,6 +281,9 @@ func (u *UserController) DeleteUser(c *gin.Context) {
 	defer cancel()
 
 	userId := c.Param("user_id")
+	if _, ok := u.authorizeUser(c, ctx, helpers.PermUsersDelete, userId); !ok {
+		return
+	}
 	if err := u.userservice.DeleteUser(ctx, userId); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -172,30 +291,100 @@ func (u *UserController) DeleteUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "account has been successfuly deleted"})
 }
 
+// authorizeUser fetches the user an action targets and checks the access
+// policies for it. On failure the response has been written.
+func (u *UserController) authorizeUser(c *gin.Context, ctx context.Context, action string, userId string) (*models.User, bool) {
+	target, err := u.userservice.GetUser(ctx, &userId)
+	var resource map[string]any
+	if err == nil {
+		resource = helpers.PolicyResource(target)
+	}
+
+	decision := u.policyservice.Authorize(helpers.NewPolicyInput(c, action, resource))
+	if !decision.Allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource", "reason": decision.Reason})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return target, true
+}
+
+// SetAttributes replaces the attributes access policies read about a user.
+func (u *UserController) SetAttributes(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var req struct {
+		Attributes map[string]string `json:"attributes" validate:"max=32,dive,keys,required,max=64,endkeys,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(req); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+
+	if err := u.userservice.SetAttributes(ctx, c.Param("user_id"), req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "attributes have been updated", "attributes": req.Attributes})
+}
+
 func (u *UserController) Refresh(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 	defer cancel()
 	refreshToken, err := c.Cookie("refresh_token")
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "no refresh token"})
+		// mobile clients send the refresh token in the body instead of a cookie
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "no refresh token"})
+			return
+		}
+		refreshToken = req.RefreshToken
+	}
+
+	jkt, err := helpers.DPoPKey(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	NewAccess, NewRefresh, _ := u.userservice.Refresh(ctx, refreshToken)
+	tokens, err := u.userservice.Refresh(ctx, refreshToken, nil, jkt, deviceFromRequest(c))
+	if errors.Is(err, services.ErrPasswordChangeRequired) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "password_change_required": true})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	c.SetCookie(
 		"refresh_token",
-		NewRefresh,
-		3600*24*7,
+		tokens.RefreshToken,
+		tokens.RefreshExpiresIn,
 		"/",
 		os.Getenv("COOKIE_DOMAIN"),
 		false,
 		true,
 	)
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":           "Tokens are refreshed",
-		"new_access_token":  NewAccess,
-		"new_refresh_token": NewRefresh,
-	})
+		"new_access_token":  tokens.AccessToken,
+		"new_refresh_token": tokens.RefreshToken,
+	}
+	if tokens.IDToken != "" {
+		response["new_id_token"] = tokens.IDToken
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (u *UserController) ForgotPassword(c *gin.Context) {
@@ -331,17 +520,158 @@ func (u *UserController) ResetPassword(c *gin.Context) {
 	}
 
 	if err := u.userservice.ResetPassword(ctx, req.Email, req.NewPassword); err != nil {
+		if passwordPolicyFailed(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// reset tokens are single use
+	if err := u.tokenservice.RevokeAccessToken(ctx, c.GetString("jti"), c.GetTime("expires_at"), "reset token used"); err != nil {
+		log.Println("error revoking reset token:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully"})
+}
+
+// ChangePassword sets a new password for the signed in user, who has to
+// confirm the current one. Other sessions are signed out.
+func (u *UserController) ChangePassword(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var req struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(req); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+	if helpers.IsClientPrincipal(c) || c.GetString("actor") != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the account owner can change the password"})
+		return
+	}
+
+	err := u.userservice.ChangePassword(ctx, c.GetString("uid"), c.GetString("sid"), c.ClientIP(), req.CurrentPassword, req.NewPassword)
+	if lockedOut(c, c.GetString("email"), err) || passwordPolicyFailed(c, err) {
+		return
+	}
+	if errors.Is(err, helpers.ErrPasswordIncorrect) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "current password is incorrect"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully"})
 }
 
-func Logout(c *gin.Context) {
-	_, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+// GetPasswordPolicy describes the password rules so that clients can show
+// them before a password is submitted.
+func (u *UserController) GetPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"policy": helpers.PasswordPolicy()})
+}
+
+// passwordPolicyFailed answers 400 with every failed rule when err is a
+// password policy error.
+func passwordPolicyFailed(c *gin.Context, err error) bool {
+	var policyErr *helpers.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":      "password does not meet the policy",
+		"violations": policyErr.Violations,
+	})
+	return true
+}
+
+// Userinfo is the OpenID Connect UserInfo endpoint. The claims returned
+// depend on the scopes granted to the access token.
+func (u *UserController) Userinfo(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	// the openid scope itself is required by middleware.RequireScope
+	scope := c.GetString("scope")
+	if helpers.IsClientPrincipal(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "userinfo is only available for user tokens"})
+		return
+	}
+
+	userId := c.GetString("uid")
+	foundUser, err := u.userservice.GetUser(ctx, &userId)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	}
+
+	claims := gin.H{"sub": foundUser.User_id}
+	if helpers.HasScope(scope, "profile") {
+		claims["preferred_username"] = foundUser.Username
+		claims["updated_at"] = foundUser.Updated_at.Unix()
+	}
+	if helpers.HasScope(scope, "email") {
+		claims["email"] = foundUser.Email
+		claims["email_verified"] = foundUser.Email_verified
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+// ApproveDevice lets a signed in user approve or deny a device flow user code.
+func (u *UserController) ApproveDevice(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var req struct {
+		UserCode string `json:"user_code" validate:"required"`
+		Approve  bool   `json:"approve"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(req); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+	if helpers.IsClientPrincipal(c) || c.GetString("actor") != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the user can approve a device"})
+		return
+	}
+
+	if err := u.tokenservice.DecideDeviceCode(ctx, helpers.NormalizeUserCode(req.UserCode), c.GetString("uid"), req.Approve); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "device decision recorded"})
+}
+
+func (u *UserController) Logout(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
 	defer cancel()
 
+	if jti := c.GetString("jti"); jti != "" {
+		if err := u.tokenservice.RevokeAccessToken(ctx, jti, c.GetTime("expires_at"), "logout"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if refreshToken, err := c.Cookie("refresh_token"); err == nil {
+		if err := u.tokenservice.RevokeRefreshToken(ctx, refreshToken); err != nil && err != services.ErrRefreshTokenInvalid {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	c.SetCookie(
 		"refresh_token",
 		"",
@@ -354,3 +684,136 @@ func Logout(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
 }
+
+func (u *UserController) RevokeToken(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	var req struct {
+		Jti    string `json:"jti"`
+		UserID string `json:"user_id"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Jti == "" && req.UserID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "jti or user_id is required"})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "revoked by admin " + c.GetString("uid")
+	}
+
+	if req.Jti != "" {
+		// the token itself is unknown here, so keep the entry for the longest token lifetime
+		expiresAt := time.Now().Add(helpers.MaxTokenLifetime())
+		if err := u.tokenservice.RevokeAccessToken(ctx, req.Jti, expiresAt, req.Reason); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.UserID != "" {
+		if err := u.tokenservice.RevokeUserTokens(ctx, req.UserID, req.Reason); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "tokens have been revoked"})
+}
+
+// ListSessions returns the active sessions of the caller. Holders of
+// sessions:read can pass a user_id query parameter to list those of any user.
+func (u *UserController) ListSessions(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	userId, err := sessionOwner(c, helpers.PermSessionsRead)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := u.tokenservice.ListSessions(ctx, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == c.GetString("sid")
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession logs a single device out.
+func (u *UserController) RevokeSession(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	userId, err := sessionOwner(c, helpers.PermSessionsRevoke)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = u.tokenservice.RevokeSession(ctx, userId, c.Param("id"), "session revoked by "+c.GetString("uid"))
+	if err == services.ErrSessionNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "session has been revoked"})
+}
+
+// RevokeOtherSessions logs out everywhere except the session making the
+// request. When acting on another user every session is revoked.
+func (u *UserController) RevokeOtherSessions(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 100*time.Second)
+	defer cancel()
+
+	userId, err := sessionOwner(c, helpers.PermSessionsRevoke)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	keep := ""
+	if userId == c.GetString("uid") {
+		keep = c.GetString("sid")
+	}
+
+	revoked, err := u.tokenservice.RevokeOtherSessions(ctx, userId, keep, "sessions revoked by "+c.GetString("uid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "sessions have been revoked", "revoked": revoked})
+}
+
+// sessionOwner resolves whose sessions a request is about: the caller's own,
+// or those of the user_id query parameter for holders of permission.
+func sessionOwner(c *gin.Context, permission string) (string, error) {
+	if helpers.IsClientPrincipal(c) {
+		return "", errors.New("sessions belong to users, not clients")
+	}
+	userId := c.Query("user_id")
+	if userId == "" {
+		return c.GetString("uid"), nil
+	}
+	if err := helpers.MatchUidOrPermission(c, userId, permission); err != nil {
+		return "", err
+	}
+	return userId, nil
+}
+
+func deviceFromRequest(c *gin.Context) models.Device {
+	return models.Device{
+		Name:      c.GetHeader("X-Device-Name"),
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
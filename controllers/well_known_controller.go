@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"go-auth/helpers"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": helpers.PublicJWKS()})
+}
+
+func OpenIDConfiguration(c *gin.Context) {
+	base := helpers.PublicURL(c)
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                helpers.Issuer(),
+		"jwks_uri":                              base + "/.well-known/jwks.json",
+		"authorization_endpoint":                base + "/v1/oauth/authorize",
+		"token_endpoint":                        base + "/v1/oauth/token",
+		"device_authorization_endpoint":         base + "/v1/oauth/device/code",
+		"userinfo_endpoint":                     base + "/v1/userinfo",
+		"introspection_endpoint":                base + "/v1/oauth/introspect",
+		"revocation_endpoint":                   base + "/v1/oauth/revoke",
+		"scopes_supported":                      helpers.SupportedUserScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", grantTypeTokenExchange, grantTypeDeviceCode},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"dpop_signing_alg_values_supported":     []string{"ES256", "RS256", "EdDSA"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{helpers.SigningAlgorithm()},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"email", "email_verified", "preferred_username",
+		},
+	})
+}
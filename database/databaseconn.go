@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -32,3 +33,24 @@ func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collecti
 	collection := client.Database("jwtauth").Collection(collectionName)
 	return collection
 }
+
+// EnsureUniqueIndex makes field unique within collection.
+func EnsureUniqueIndex(collection *mongo.Collection, field string) {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := collection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		log.Println("error creating unique index on", collection.Name(), err)
+	}
+}
+
+func EnsureTTLIndex(collection *mongo.Collection, field string) {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := collection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		log.Println("error creating ttl index on", collection.Name(), err)
+	}
+}
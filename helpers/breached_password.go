@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"go-auth/breachfilter"
+	"log"
+	"os"
+)
+
+// breachFilter holds the SHA-1 hashes of breached passwords. Screening is
+// off while it is nil.
+var breachFilter *breachfilter.Filter
+
+// InitBreachFilter loads the filter file named by BREACHED_PASSWORD_FILTER,
+// built with cmd/breachfilter.
+func InitBreachFilter() error {
+	path := os.Getenv("BREACHED_PASSWORD_FILTER")
+	if path == "" {
+		return nil
+	}
+	filter, err := breachfilter.Load(path)
+	if err != nil {
+		return err
+	}
+	breachFilter = filter
+	passwordPolicy.RejectBreached = true
+	log.Printf("loaded breached password filter with %d hashes", filter.Count())
+	return nil
+}
+
+// IsPasswordBreached reports whether password is in the breach filter.
+// Rarely, a password that was never breached is reported too.
+func IsPasswordBreached(password string) bool {
+	return breachFilter != nil && breachFilter.ContainsPassword(password)
+}
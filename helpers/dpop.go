@@ -0,0 +1,216 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dpopProofWindow is how far a proof's iat may be from the server clock.
+const dpopProofWindow = time.Minute
+
+var ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+// Confirmation is the cnf claim binding a token to a DPoP key (RFC 9449).
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+type dpopClaims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// dpopProofCollection remembers the proofs seen within the proof window, so
+// that a proof cannot be replayed against any replica. Its TTL index sweeps
+// the expired ones.
+var dpopProofCollection *mongo.Collection
+
+// InitDPoP sets the collection seen proofs are kept in.
+func InitDPoP(proofcollection *mongo.Collection) {
+	dpopProofCollection = proofcollection
+}
+
+// VerifyDPoPProof checks a DPoP proof JWT for the given request and returns
+// the thumbprint of the key that signed it. When accessToken is not empty
+// the proof must also carry its hash in the ath claim.
+func VerifyDPoPProof(ctx context.Context, proof string, method string, url string, accessToken string) (string, error) {
+	var jwk map[string]any
+	token, err := jwt.ParseWithClaims(proof, &dpopClaims{}, func(token *jwt.Token) (any, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, errors.New("typ must be dpop+jwt")
+		}
+		jwk, _ = token.Header["jwk"].(map[string]any)
+		return parsePublicJWK(jwk)
+	}, jwt.WithValidMethods([]string{"ES256", "RS256", "EdDSA"}), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	claims := token.Claims.(*dpopClaims)
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return "", ErrInvalidDPoPProof
+	}
+	if !strings.EqualFold(claims.HTM, method) || stripQuery(claims.HTU) != stripQuery(url) {
+		return "", ErrInvalidDPoPProof
+	}
+	if age := time.Since(claims.IssuedAt.Time); age > dpopProofWindow || age < -dpopProofWindow {
+		return "", ErrInvalidDPoPProof
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", ErrInvalidDPoPProof
+		}
+	}
+
+	jkt, err := JWKThumbprint(jwk)
+	if err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+	fresh, err := markProofSeen(ctx, jkt+":"+claims.ID)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", ErrInvalidDPoPProof
+	}
+	return jkt, nil
+}
+
+// DPoPKey verifies the DPoP header of a token request, if any, and returns
+// the thumbprint to bind the issued tokens to. accessToken is a token the
+// request presents, whose hash the proof must carry, or empty.
+func DPoPKey(c *gin.Context, accessToken string) (string, error) {
+	proof := c.GetHeader("DPoP")
+	if proof == "" {
+		return "", nil
+	}
+	return VerifyDPoPProof(c.Request.Context(), proof, c.Request.Method, RequestURL(c), accessToken)
+}
+
+// RequestURL is the htu a DPoP proof for the current request must carry.
+func RequestURL(c *gin.Context) string {
+	return PublicURL(c) + c.Request.URL.Path
+}
+
+// JWKThumbprint computes the RFC 7638 SHA-256 thumbprint of a public JWK.
+func JWKThumbprint(jwk map[string]any) (string, error) {
+	var members []string
+	switch jwk["kty"] {
+	case "EC":
+		members = []string{"crv", "kty", "x", "y"}
+	case "RSA":
+		members = []string{"e", "kty", "n"}
+	case "OKP":
+		members = []string{"crv", "kty", "x"}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+
+	// the members are already in lexicographic order
+	parts := make([]string, len(members))
+	for i, name := range members {
+		value, ok := jwk[name].(string)
+		if !ok {
+			return "", errors.New("missing jwk member " + name)
+		}
+		encoded, _ := json.Marshal(value)
+		parts[i] = `"` + name + `":` + string(encoded)
+	}
+	sum := sha256.Sum256([]byte("{" + strings.Join(parts, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func parsePublicJWK(jwk map[string]any) (crypto.PublicKey, error) {
+	if jwk == nil {
+		return nil, errors.New("missing jwk header")
+	}
+	if _, ok := jwk["d"]; ok {
+		return nil, errors.New("jwk must not contain a private key")
+	}
+	member := func(name string) ([]byte, error) {
+		value, _ := jwk[name].(string)
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+
+	switch jwk["kty"] {
+	case "EC":
+		if jwk["crv"] != "P-256" {
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := member("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := member("y")
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return key, nil
+	case "RSA":
+		n, err := member("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := member("e")
+		if err != nil {
+			return nil, err
+		}
+		if len(n) < 256 {
+			return nil, errors.New("RSA key is too small")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := member("x")
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// markProofSeen returns false if the proof was already used. Proofs are
+// kept for twice the window, since their iat may lie that far apart.
+func markProofSeen(ctx context.Context, key string) (bool, error) {
+	_, err := dpopProofCollection.InsertOne(ctx, bson.M{
+		"_id":        HashToken(key),
+		"expires_at": time.Now().Add(2 * dpopProofWindow),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func stripQuery(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// SendEmail renders the HTML template with data and sends it through
+// SendGrid.
+func SendEmail(to string, subject string, plainText string, templateFile string, data any) error {
+	from := os.Getenv("SENDGRID_FROM_EMAIL")
+	apiKey := os.Getenv("SENDGRID_API_KEY")
+	if from == "" || apiKey == "" {
+		return errors.New("server misconfiguration: SendGrid is not configured")
+	}
+
+	t, err := template.ParseFiles(templateFile)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+
+	message := mail.NewSingleEmail(mail.NewEmail("", from), subject, mail.NewEmail("", to), plainText, body.String())
+	response, err := sendgrid.NewSendClient(apiKey).Send(message)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid answered %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,796 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled policy condition. The language is a small subset
+// of CEL: literals (strings, numbers, true, false, null, lists), member and
+// index access, the operators ! && || == != < <= > >= in + -, the functions
+// has(a.b) and size(x), and the string methods startsWith, endsWith,
+// contains and matches. Accessing a missing field is an error; guard it
+// with has().
+type Expression struct {
+	source string
+	root   exprNode
+}
+
+// CompileExpression parses source and checks that it only refers to the
+// given variables.
+func CompileExpression(source string, variables ...string) (*Expression, error) {
+	p := &exprParser{lexer: exprLexer{input: source}}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	// a lexer error ends the token stream early, which the parser may
+	// have taken for the end of the expression
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.tok.text, p.tok.pos)
+	}
+	if err := checkIdentifiers(root, variables); err != nil {
+		return nil, err
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// EvalBool evaluates the expression and requires a bool result.
+func (e *Expression) EvalBool(vars map[string]any) (bool, error) {
+	value, err := e.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression is %s, not bool", typeName(value))
+	}
+	return b, nil
+}
+
+// lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprLexer struct {
+	input string
+	pos   int
+}
+
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "(", ")", "[", "]", ",", "."}
+
+func (l *exprLexer) next() (exprToken, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return exprToken{kind: tokEOF, pos: start}, nil
+	}
+
+	ch := l.input[l.pos]
+	switch {
+	case ch == '_' || unicode.IsLetter(rune(ch)):
+		for l.pos < len(l.input) && (l.input[l.pos] == '_' || unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos]))) {
+			l.pos++
+		}
+		return exprToken{kind: tokIdent, text: l.input[start:l.pos], pos: start}, nil
+	case unicode.IsDigit(rune(ch)):
+		for l.pos < len(l.input) && (unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '.') {
+			l.pos++
+		}
+		return exprToken{kind: tokNumber, text: l.input[start:l.pos], pos: start}, nil
+	case ch == '"' || ch == '\'':
+		l.pos++
+		var sb strings.Builder
+		for l.pos < len(l.input) && l.input[l.pos] != ch {
+			if l.input[l.pos] == '\\' && l.pos+1 < len(l.input) {
+				l.pos++
+			}
+			sb.WriteByte(l.input[l.pos])
+			l.pos++
+		}
+		if l.pos >= len(l.input) {
+			return exprToken{}, fmt.Errorf("unterminated string at %d", start)
+		}
+		l.pos++
+		return exprToken{kind: tokString, text: sb.String(), pos: start}, nil
+	}
+
+	for _, op := range exprOperators {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return exprToken{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return exprToken{}, fmt.Errorf("unexpected character %q at %d", ch, start)
+}
+
+// parser
+
+type exprParser struct {
+	lexer exprLexer
+	tok   exprToken
+	err   error
+}
+
+func (p *exprParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lexer.next()
+	if p.err != nil {
+		p.tok = exprToken{kind: tokEOF}
+	}
+}
+
+func (p *exprParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.unexpected("expected " + op)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) unexpected(context string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("%s, found end of expression", context)
+	}
+	return fmt.Errorf("%s, found %q at %d", context, p.tok.text, p.tok.pos)
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isOp("||") {
+		p.next()
+		var right exprNode
+		if right, err = p.parseAnd(); err == nil {
+			left = &logicalNode{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseRelation()
+	for err == nil && p.isOp("&&") {
+		p.next()
+		var right exprNode
+		if right, err = p.parseRelation(); err == nil {
+			left = &logicalNode{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseRelation() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		switch {
+		case p.tok.kind == tokOp && slices.Contains([]string{"==", "!=", "<", "<=", ">", ">="}, p.tok.text):
+			op = p.tok.text
+		case p.tok.kind == tokIdent && p.tok.text == "in":
+			op = "in"
+		default:
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && (p.isOp("+") || p.isOp("-")) {
+		op := p.tok.text
+		p.next()
+		var right exprNode
+		if right, err = p.parseUnary(); err == nil {
+			left = &binaryNode{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("!") || p.isOp("-") {
+		op := p.tok.text
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	for err == nil {
+		switch {
+		case p.isOp("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				return nil, p.unexpected("expected a field name")
+			}
+			name := p.tok.text
+			p.next()
+			if p.isOp("(") {
+				var args []exprNode
+				if args, err = p.parseArgs(); err == nil {
+					node = &callNode{name: name, target: node, args: args}
+				}
+			} else {
+				node = &memberNode{operand: node, field: name}
+			}
+		case p.isOp("["):
+			p.next()
+			var key exprNode
+			if key, err = p.parseOr(); err == nil {
+				if err = p.expect("]"); err == nil {
+					node = &indexNode{operand: node, key: key}
+				}
+			}
+		default:
+			return node, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []exprNode
+	for !p.isOp(")") {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expect(")")
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", tok.text, tok.pos)
+		}
+		return &literalNode{value: n}, nil
+	case tokString:
+		p.next()
+		return &literalNode{value: tok.text}, nil
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.isOp("(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			if tok.text == "has" && (len(args) != 1 || !isMember(args[0])) {
+				return nil, errors.New("has() takes a single field selection like has(a.b)")
+			}
+			return &callNode{name: tok.text, args: args}, nil
+		}
+		return &identNode{name: tok.text}, nil
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+		if tok.text == "[" {
+			p.next()
+			var items []exprNode
+			for !p.isOp("]") {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return &listNode{items: items}, p.expect("]")
+		}
+	}
+	return nil, p.unexpected("expected a value")
+}
+
+func isMember(node exprNode) bool {
+	_, ok := node.(*memberNode)
+	return ok
+}
+
+func checkIdentifiers(node exprNode, variables []string) error {
+	switch n := node.(type) {
+	case *identNode:
+		if !slices.Contains(variables, n.name) {
+			return fmt.Errorf("unknown variable %q, expected one of %s", n.name, strings.Join(variables, ", "))
+		}
+	case *memberNode:
+		return checkIdentifiers(n.operand, variables)
+	case *indexNode:
+		if err := checkIdentifiers(n.operand, variables); err != nil {
+			return err
+		}
+		return checkIdentifiers(n.key, variables)
+	case *unaryNode:
+		return checkIdentifiers(n.operand, variables)
+	case *binaryNode:
+		if err := checkIdentifiers(n.left, variables); err != nil {
+			return err
+		}
+		return checkIdentifiers(n.right, variables)
+	case *logicalNode:
+		if err := checkIdentifiers(n.left, variables); err != nil {
+			return err
+		}
+		return checkIdentifiers(n.right, variables)
+	case *listNode:
+		for _, item := range n.items {
+			if err := checkIdentifiers(item, variables); err != nil {
+				return err
+			}
+		}
+	case *callNode:
+		if n.target != nil {
+			if !slices.Contains([]string{"startsWith", "endsWith", "contains", "matches"}, n.name) {
+				return fmt.Errorf("unknown method %q", n.name)
+			}
+			if err := checkIdentifiers(n.target, variables); err != nil {
+				return err
+			}
+		} else if n.name != "has" && n.name != "size" {
+			return fmt.Errorf("unknown function %q", n.name)
+		}
+		for _, arg := range n.args {
+			if err := checkIdentifiers(arg, variables); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evaluation
+
+type exprNode interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literalNode struct{ value any }
+
+type identNode struct{ name string }
+
+type memberNode struct {
+	operand exprNode
+	field   string
+}
+
+type indexNode struct {
+	operand exprNode
+	key     exprNode
+}
+
+type listNode struct{ items []exprNode }
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type logicalNode struct {
+	op          string
+	left, right exprNode
+}
+
+type callNode struct {
+	name   string
+	target exprNode
+	args   []exprNode
+}
+
+func (n *literalNode) eval(map[string]any) (any, error) {
+	return n.value, nil
+}
+
+func (n *identNode) eval(vars map[string]any) (any, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %q", n.name)
+	}
+	return normalizeValue(value), nil
+}
+
+func (n *memberNode) eval(vars map[string]any) (any, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := operand.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot select %q from %s", n.field, typeName(operand))
+	}
+	value, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key %q", n.field)
+	}
+	return normalizeValue(value), nil
+}
+
+func (n *indexNode) eval(vars map[string]any) (any, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch o := operand.(type) {
+	case map[string]any:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys are strings, not %s", typeName(key))
+		}
+		value, ok := o[k]
+		if !ok {
+			return nil, fmt.Errorf("no such key %q", k)
+		}
+		return normalizeValue(value), nil
+	case []any:
+		i, ok := key.(float64)
+		if !ok || i != float64(int(i)) || int(i) < 0 || int(i) >= len(o) {
+			return nil, fmt.Errorf("index %v out of range", key)
+		}
+		return o[int(i)], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(operand))
+}
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+	items := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+func (n *unaryNode) eval(vars map[string]any) (any, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, ok := operand.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", typeName(operand))
+		}
+		return !b, nil
+	}
+	f, ok := operand.(float64)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %s", typeName(operand))
+	}
+	return -f, nil
+}
+
+// eval of && and || follows CEL: an error on one side is absorbed when the
+// other side alone decides the result.
+func (n *logicalNode) eval(vars map[string]any) (any, error) {
+	decisive := n.op == "||"
+	left, leftErr := evalBool(n.left, vars)
+	if leftErr == nil && left == decisive {
+		return decisive, nil
+	}
+	right, rightErr := evalBool(n.right, vars)
+	if rightErr == nil && right == decisive {
+		return decisive, nil
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	return !decisive, nil
+}
+
+func evalBool(node exprNode, vars map[string]any) (bool, error) {
+	value, err := node.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %s", typeName(value))
+	}
+	return b, nil
+}
+
+func (n *binaryNode) eval(vars map[string]any) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []any:
+			return slices.ContainsFunc(r, func(item any) bool { return reflect.DeepEqual(item, left) }), nil
+		case map[string]any:
+			k, ok := left.(string)
+			if !ok {
+				return false, nil
+			}
+			_, found := r[k]
+			return found, nil
+		}
+		return nil, fmt.Errorf("cannot use in with %s", typeName(right))
+	case "+":
+		switch l := left.(type) {
+		case float64:
+			if r, ok := right.(float64); ok {
+				return l + r, nil
+			}
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []any:
+			if r, ok := right.([]any); ok {
+				return append(slices.Clone(l), r...), nil
+			}
+		}
+	case "-":
+		l, lok := left.(float64)
+		r, rok := right.(float64)
+		if lok && rok {
+			return l - r, nil
+		}
+	default:
+		cmp, ok := compareValues(left, right)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		case ">=":
+			return cmp >= 0, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot apply %s to %s and %s", n.op, typeName(left), typeName(right))
+}
+
+func compareValues(left any, right any) (int, bool) {
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	}
+	return 0, false
+}
+
+func (n *callNode) eval(vars map[string]any) (any, error) {
+	if n.name == "has" {
+		member := n.args[0].(*memberNode)
+		operand, err := member.operand.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := operand.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot select %q from %s", member.field, typeName(operand))
+		}
+		_, found := m[member.field]
+		return found, nil
+	}
+
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	if n.target == nil {
+		// size is the only other function
+		if len(args) != 1 {
+			return nil, errors.New("size() takes one argument")
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("size() of %s", typeName(args[0]))
+	}
+
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := target.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s() is only defined on strings, not %s", n.name, typeName(target))
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s() takes one argument", n.name)
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s() takes a string, not %s", n.name, typeName(args[0]))
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "contains":
+		return strings.Contains(s, arg), nil
+	case "matches":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	}
+	return nil, fmt.Errorf("unknown method %q", n.name)
+}
+
+// normalizeValue converts the Go values put into the variables to the few
+// types the language works with.
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case []string:
+		items := make([]any, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return m
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = normalizeValue(item)
+		}
+		return items
+	}
+	return value
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,136 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func exprVars() map[string]any {
+	return map[string]any{
+		"subject": map[string]any{
+			"user_id":    "u1",
+			"roles":      []string{"admin", "support"},
+			"attributes": map[string]string{"department": "eng"},
+			"level":      3,
+		},
+		"resource": map[string]any{
+			"owner": "u1",
+			"tags":  []any{"a", "b"},
+		},
+		"request": map[string]any{"ip": "10.0.0.1"},
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    bool
+		wantErr string
+	}{
+		// precedence and associativity
+		{name: "and binds tighter than or", source: `true || false && false`, want: true},
+		{name: "or of ands", source: `false && false || true`, want: true},
+		{name: "not binds tighter than or", source: `!true || true`, want: true},
+		{name: "parentheses", source: `!(true || true)`, want: false},
+		{name: "arithmetic before comparison", source: `1 + 2 == 3 && subject.level - 1 > 1`, want: true},
+		{name: "subtraction is left associative", source: `1 - 2 - 3 == -4`, want: true},
+		{name: "comparison before and", source: `subject.level >= 3 && "a" < "b"`, want: true},
+
+		// member and index access
+		{name: "member", source: `resource.owner == subject.user_id`, want: true},
+		{name: "string map index", source: `subject.attributes["department"] == "eng"`, want: true},
+		{name: "list index", source: `resource.tags[1] == "b"`, want: true},
+		{name: "index out of range", source: `resource.tags[2] == "c"`, wantErr: "out of range"},
+
+		// has()
+		{name: "has present", source: `has(subject.attributes.department)`, want: true},
+		{name: "has absent", source: `has(subject.team)`, want: false},
+		{name: "has on missing parent", source: `has(subject.team.name)`, wantErr: `no such key "team"`},
+
+		// in
+		{name: "in list", source: `"admin" in subject.roles`, want: true},
+		{name: "not in list", source: `"owner" in subject.roles`, want: false},
+		{name: "in list literal", source: `request.ip in ["10.0.0.1", "10.0.0.2"]`, want: true},
+		{name: "in map keys", source: `"department" in subject.attributes`, want: true},
+		{name: "in string", source: `"a" in "abc"`, wantErr: "cannot use in with string"},
+
+		// functions and methods
+		{name: "size", source: `size(subject.roles) == 2 && size("héllo") == 5`, want: true},
+		{name: "startsWith", source: `request.ip.startsWith("10.")`, want: true},
+		{name: "matches", source: `subject.user_id.matches("^u[0-9]+$")`, want: true},
+		{name: "method on number", source: `subject.level.contains("3")`, wantErr: "only defined on strings"},
+
+		// missing fields
+		{name: "missing field", source: `subject.team == "ops"`, wantErr: `no such key "team"`},
+		{name: "missing map key", source: `subject.attributes["site"] == "x"`, wantErr: `no such key "site"`},
+
+		// && and || absorb an error when the other side decides
+		{name: "and guarded by has", source: `has(subject.team) && subject.team == "ops"`, want: false},
+		{name: "and decided by right", source: `subject.team == "ops" && false`, want: false},
+		{name: "and not decided", source: `subject.team == "ops" && true`, wantErr: `no such key "team"`},
+		{name: "or decided by right", source: `subject.team == "ops" || true`, want: true},
+		{name: "or not decided", source: `subject.team == "ops" || false`, wantErr: `no such key "team"`},
+		{name: "or of errors", source: `subject.team == "a" || resource.kind == "b"`, wantErr: `no such key "team"`},
+
+		// type errors
+		{name: "not a bool", source: `1 + 1`, wantErr: "not bool"},
+		{name: "negate a string", source: `!subject.user_id`, wantErr: "cannot negate string"},
+		{name: "compare mixed types", source: `subject.level < "4"`, wantErr: "cannot apply <"},
+		{name: "logical on number", source: `subject.level && true`, wantErr: "expected bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := CompilePolicyCondition(tt.source)
+			if err != nil {
+				t.Fatalf("compile %q: %v", tt.source, err)
+			}
+			got, err := expr.EvalBool(exprVars())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EvalBool(%q) error = %v, want %q", tt.source, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalBool(%q): %v", tt.source, err)
+			}
+			if got != tt.want {
+				t.Errorf("EvalBool(%q) = %v, want %v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{name: "empty", source: ``, wantErr: "expected a value"},
+		{name: "dangling operator", source: `1 +`, wantErr: "expected a value"},
+		{name: "dangling dot", source: `subject.`, wantErr: "expected a field name"},
+		{name: "unclosed parenthesis", source: `(true`, wantErr: "expected )"},
+		{name: "unclosed list", source: `"a" in ["a", "b"`, wantErr: "expected ]"},
+		{name: "unterminated string", source: `subject.user_id == "u1`, wantErr: "unterminated string"},
+		{name: "unknown character", source: `subject.level # 1`, wantErr: "unexpected character"},
+		{name: "trailing tokens", source: `true false`, wantErr: `unexpected "false"`},
+		{name: "single equals", source: `subject.user_id = "u1"`, wantErr: "unexpected character"},
+		{name: "invalid number", source: `1.2.3 == 1`, wantErr: "invalid number"},
+		{name: "unknown variable", source: `user.id == "u1"`, wantErr: `unknown variable "user"`},
+		{name: "unknown function", source: `len(subject.roles) == 2`, wantErr: `unknown function "len"`},
+		{name: "unknown method", source: `subject.user_id.lower() == "u1"`, wantErr: `unknown method "lower"`},
+		{name: "has without selection", source: `has(subject)`, wantErr: "has() takes a single field selection"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompilePolicyCondition(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("compile %q error = %v, want %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package helpers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Nested JWTs (RFC 7516, RFC 7518): tokens are signed by the keyring first
+// and the resulting JWS is encrypted, so only holders of the decryption key
+// can read the claims.
+const (
+	jweDirect       = "dir"
+	jweECDHES       = "ECDH-ES"
+	jweContentAlg   = "A256GCM"
+	jweDirectKeyLen = 32
+)
+
+var errInvalidJWE = errors.New("token is invalid")
+
+type jweHeader struct {
+	Alg string            `json:"alg"`
+	Enc string            `json:"enc"`
+	Cty string            `json:"cty"`
+	Kid string            `json:"kid"`
+	Epk map[string]string `json:"epk,omitempty"`
+}
+
+type jweFormat struct {
+	algorithm string
+	ring      *KeyRing
+}
+
+// newJWEFormat sets up encryption from the environment: JWE_ALG selects
+// dir (default, a shared 32 byte key) or ECDH-ES (a P-256 key pair). The
+// keys live in <JWT_KEYS_DIR>/enc and are rotated and retired like the
+// signing keys, so replicas must share the directory as well.
+func newJWEFormat() (*jweFormat, error) {
+	algorithm := os.Getenv("JWE_ALG")
+	if algorithm == "" {
+		algorithm = jweDirect
+	}
+	if algorithm != jweDirect && algorithm != jweECDHES {
+		return nil, fmt.Errorf("unsupported JWE_ALG %q", algorithm)
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR is not set, tokens encrypted with a temporary key will not survive a restart")
+	} else {
+		dir = filepath.Join(dir, "enc")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	ring, err := loadKeyRing(algorithm, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &jweFormat{algorithm: algorithm, ring: ring}, nil
+}
+
+func (j *jweFormat) Name() string {
+	return "jwe"
+}
+
+func (j *jweFormat) Sign(claims *SignedDetails) (string, error) {
+	signed, err := SignClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	key := j.ring.active()
+	header := jweHeader{Alg: j.algorithm, Enc: jweContentAlg, Cty: "JWT", Kid: key.Kid}
+	cek := key.Secret
+	if j.algorithm == jweECDHES {
+		private, err := ecdhKey(key)
+		if err != nil {
+			return "", err
+		}
+		ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		shared, err := ephemeral.ECDH(private.PublicKey())
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(shared, jweContentAlg, nil, nil, jweDirectKeyLen*8)
+		header.Epk = ecdhPublicJWK(ephemeral.PublicKey())
+	}
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := b64(rawHeader)
+
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(signed), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	// there is no encrypted key with dir and direct ECDH-ES
+	return strings.Join([]string{protected, "", b64(iv), b64(ciphertext), b64(tag)}, "."), nil
+}
+
+func (j *jweFormat) Parse(token string) (*SignedDetails, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return nil, errInvalidJWE
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidJWE
+	}
+	var header jweHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, errInvalidJWE
+	}
+	// only the configured algorithms are accepted, whatever the header says
+	if header.Alg != j.algorithm || header.Enc != jweContentAlg || header.Cty != "JWT" {
+		return nil, errInvalidJWE
+	}
+	key := j.ring.find(header.Kid)
+	if key == nil {
+		return nil, errors.New("unknown encryption key")
+	}
+
+	cek := key.Secret
+	if j.algorithm == jweECDHES {
+		private, err := ecdhKey(key)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := ecdhPublicKey(header.Epk)
+		if err != nil {
+			return nil, errInvalidJWE
+		}
+		shared, err := private.ECDH(ephemeral)
+		if err != nil {
+			return nil, errInvalidJWE
+		}
+		cek = concatKDF(shared, jweContentAlg, nil, nil, jweDirectKeyLen*8)
+	}
+
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidJWE
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, errInvalidJWE
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, errInvalidJWE
+	}
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, errInvalidJWE
+	}
+	signed, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, errInvalidJWE
+	}
+	return jwtFormat{}.Parse(string(signed))
+}
+
+// ecdhKey converts the P-256 key pair of an ECDH-ES key for key agreement.
+func ecdhKey(key *SigningKey) (*ecdh.PrivateKey, error) {
+	private, ok := key.Private.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("encryption key is not a P-256 key")
+	}
+	return private.ECDH()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// concatKDF derives a keyBits long key for direct ECDH-ES (RFC 7518
+// section 4.6.2). Our tokens leave apu and apv empty.
+func concatKDF(shared []byte, algorithmID string, apu []byte, apv []byte, keyBits int) []byte {
+	lengthPrefixed := func(out []byte, data []byte) []byte {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+		return append(out, data...)
+	}
+	otherInfo := lengthPrefixed(nil, []byte(algorithmID))
+	otherInfo = lengthPrefixed(otherInfo, apu)
+	otherInfo = lengthPrefixed(otherInfo, apv)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keyBits))
+
+	var key []byte
+	for round := uint32(1); len(key)*8 < keyBits; round++ {
+		h := sha256.New()
+		h.Write(binary.BigEndian.AppendUint32(nil, round))
+		h.Write(shared)
+		h.Write(otherInfo)
+		key = h.Sum(key)
+	}
+	return key[:keyBits/8]
+}
+
+func ecdhPublicJWK(public *ecdh.PublicKey) map[string]string {
+	point := public.Bytes() // 0x04 || x || y
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   b64(point[1:33]),
+		"y":   b64(point[33:]),
+	}
+}
+
+func ecdhPublicKey(jwk map[string]string) (*ecdh.PublicKey, error) {
+	if jwk["kty"] != "EC" || jwk["crv"] != "P-256" {
+		return nil, errors.New("unsupported ephemeral key")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk["x"])
+	if err != nil || len(x) != 32 {
+		return nil, errors.New("invalid ephemeral key")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk["y"])
+	if err != nil || len(y) != 32 {
+		return nil, errors.New("invalid ephemeral key")
+	}
+	// NewPublicKey rejects points that are not on the curve
+	return ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+}
@@ -0,0 +1,214 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 7518 Appendix C: ECDH-ES key agreement between Alice's ephemeral key
+// and Bob's static key, then Concat KDF for A128GCM.
+func TestConcatKDFVector(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	alice, err := ecdh.P256().NewPrivateKey(decode("0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ecdhPublicKey(map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   "weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ",
+		"y":   "e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared, err := alice.ECDH(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantShared := []byte{158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196}
+	if !bytes.Equal(shared, wantShared) {
+		t.Fatalf("Z = %v, want %v", shared, wantShared)
+	}
+
+	key := concatKDF(shared, "A128GCM", []byte("Alice"), []byte("Bob"), 128)
+	if got, want := b64(key), "VqqN6vgjbSBcIijNcacQGg"; got != want {
+		t.Errorf("concatKDF = %s, want %s", got, want)
+	}
+}
+
+func TestConcatKDFLongKey(t *testing.T) {
+	shared := bytes.Repeat([]byte{7}, 32)
+	key := concatKDF(shared, "A256GCM", nil, nil, 384)
+	if len(key) != 48 {
+		t.Fatalf("len = %d, want 48", len(key))
+	}
+	// keydatalen is part of otherInfo, so a longer key is not an extension
+	if bytes.HasPrefix(key, concatKDF(shared, "A256GCM", nil, nil, 256)) {
+		t.Error("a 384 bit key extends the 256 bit one")
+	}
+}
+
+// withHS256KeyRing swaps in a signing keyring holding a single secret.
+func withHS256KeyRing(t *testing.T) {
+	t.Helper()
+	previous := keyRing
+	keyRing = &KeyRing{
+		algorithm: "HS256",
+		keys:      []*SigningKey{{Kid: "hs256", Algorithm: "HS256", Secret: []byte("test secret"), CreatedAt: time.Now()}},
+	}
+	t.Cleanup(func() { keyRing = previous })
+}
+
+func newTestJWEFormat(t *testing.T, algorithm string, dir string) *jweFormat {
+	t.Helper()
+	ring, err := loadKeyRing(algorithm, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jweFormat{algorithm: algorithm, ring: ring}
+}
+
+func jweHeaderOf(t *testing.T, token string) jweHeader {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header jweHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func TestJWERoundTrip(t *testing.T) {
+	withHS256KeyRing(t)
+	for _, algorithm := range []string{jweDirect, jweECDHES} {
+		t.Run(algorithm, func(t *testing.T) {
+			format := newTestJWEFormat(t, algorithm, "")
+			token, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header := jweHeaderOf(t, token); header.Alg != algorithm || header.Enc != jweContentAlg {
+				t.Errorf("header = %+v", header)
+			}
+			claims, err := format.Parse(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := testClaims(); claims.Subject != want.Subject || claims.ID != want.ID {
+				t.Errorf("Parse = %+v, want %+v", claims, want)
+			}
+		})
+	}
+}
+
+func TestJWETampered(t *testing.T) {
+	withHS256KeyRing(t)
+	for _, algorithm := range []string{jweDirect, jweECDHES} {
+		t.Run(algorithm, func(t *testing.T) {
+			format := newTestJWEFormat(t, algorithm, "")
+			token, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts := strings.Split(token, ".")
+			for _, i := range []int{0, 2, 3, 4} {
+				raw, _ := base64.RawURLEncoding.DecodeString(parts[i])
+				raw[len(raw)/2] ^= 1
+				tampered := append([]string{}, parts...)
+				tampered[i] = b64(raw)
+				if _, err := format.Parse(strings.Join(tampered, ".")); err == nil {
+					t.Errorf("Parse accepted a token with part %d modified", i)
+				}
+			}
+		})
+	}
+}
+
+func TestJWEWrongKid(t *testing.T) {
+	withHS256KeyRing(t)
+	for _, algorithm := range []string{jweDirect, jweECDHES} {
+		t.Run(algorithm, func(t *testing.T) {
+			format := newTestJWEFormat(t, algorithm, "")
+			token, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a token for another key ring names a kid this one does not hold
+			other, err := newTestJWEFormat(t, algorithm, "").Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := format.Parse(other); err == nil {
+				t.Error("Parse accepted a token encrypted to an unknown key")
+			}
+
+			// the header is authenticated, so pointing it at a known key fails too
+			if err := format.ring.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			header := jweHeaderOf(t, token)
+			header.Kid = format.ring.active().Kid
+			raw, _ := json.Marshal(header)
+			parts := strings.Split(token, ".")
+			parts[0] = b64(raw)
+			if _, err := format.Parse(strings.Join(parts, ".")); err == nil {
+				t.Error("Parse accepted a token whose kid was changed")
+			}
+		})
+	}
+}
+
+func TestJWERotation(t *testing.T) {
+	withHS256KeyRing(t)
+	for _, algorithm := range []string{jweDirect, jweECDHES} {
+		t.Run(algorithm, func(t *testing.T) {
+			dir := t.TempDir()
+			format := newTestJWEFormat(t, algorithm, dir)
+			before, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			previous := jweHeaderOf(t, before).Kid
+
+			if err := format.ring.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			// the new key is not used before every replica has loaded it
+			during, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid := jweHeaderOf(t, during).Kid; kid != previous {
+				t.Errorf("encrypted with %s during the publish delay, want %s", kid, previous)
+			}
+
+			// another replica reading the directory accepts both keys
+			replica := newTestJWEFormat(t, algorithm, dir)
+			if len(replica.ring.keys) != 2 {
+				t.Fatalf("replica loaded %d keys, want 2", len(replica.ring.keys))
+			}
+			for _, token := range []string{before, during} {
+				if _, err := replica.Parse(token); err != nil {
+					t.Errorf("replica rejected a token of the previous key: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,561 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SigningKey struct {
+	Kid       string
+	Algorithm string
+	Private   crypto.Signer
+	Secret    []byte
+	CreatedAt time.Time
+	RetiredAt time.Time
+}
+
+// secretKeyPEMType marks the raw symmetric keys of JWE dir encryption.
+const secretKeyPEMType = "SECRET KEY"
+
+// Replicas share keys through JWT_KEYS_DIR and reload it every
+// keyReloadInterval. A new key is only published for keyPublishDelay before
+// it signs, so that every replica accepts its tokens by then.
+const (
+	keyReloadInterval = time.Minute
+	keyPublishDelay   = 2 * keyReloadInterval
+)
+
+// KeyRing holds the active signing key and the retired keys that are still
+// accepted for verification. keys are sorted newest first; see active.
+// The JWE encryption keys are kept in a KeyRing of their own.
+type KeyRing struct {
+	mu        sync.RWMutex
+	algorithm string
+	dir       string
+	interval  time.Duration
+	retention time.Duration
+	keys      []*SigningKey
+}
+
+var keyRing *KeyRing
+
+// InitKeyRing configures token signing from the environment:
+// JWT_SIGNING_ALG selects HS256 (default, uses SECRET_KEY), RS256, ES256 or EdDSA
+// (the default and only choice with TOKEN_FORMAT=v4.public),
+// JWT_KEYS_DIR holds PEM encoded private keys named <kid>.pem,
+// JWT_KEY_ROTATION_INTERVAL and JWT_KEY_RETENTION control scheduled rotation.
+// Replicas must share JWT_KEYS_DIR, otherwise each signs with its own keys.
+func InitKeyRing() error {
+	algorithm := os.Getenv("JWT_SIGNING_ALG")
+	if algorithm == "" {
+		algorithm = "HS256"
+		if tokenFormat.Name() == "v4.public" {
+			algorithm = "EdDSA"
+		}
+	}
+	if tokenFormat.Name() == "v4.public" && algorithm != "EdDSA" {
+		return errors.New("TOKEN_FORMAT=v4.public needs JWT_SIGNING_ALG=EdDSA")
+	}
+
+	if algorithm == "HS256" {
+		if SECRET_KEY == "" {
+			return errors.New("SECRET_KEY must be set for HS256 signing")
+		}
+		keyRing = &KeyRing{
+			algorithm: algorithm,
+			keys:      []*SigningKey{{Kid: "hs256", Algorithm: algorithm, Secret: []byte(SECRET_KEY), CreatedAt: time.Now()}},
+		}
+		return nil
+	}
+
+	if _, err := signingMethod(algorithm); err != nil {
+		return err
+	}
+	ring, err := loadKeyRing(algorithm, os.Getenv("JWT_KEYS_DIR"))
+	if err != nil {
+		return err
+	}
+	keyRing = ring
+	return nil
+}
+
+// loadKeyRing reads the keys for algorithm from dir, which may be empty to
+// keep them in memory only, and creates the first key if there is none.
+// JWT_KEY_ROTATION_INTERVAL and JWT_KEY_RETENTION apply to every ring.
+func loadKeyRing(algorithm string, dir string) (*KeyRing, error) {
+	interval, err := durationFromEnv("JWT_KEY_ROTATION_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := durationFromEnv("JWT_KEY_RETENTION", MaxTokenLifetime())
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &KeyRing{
+		algorithm: algorithm,
+		dir:       dir,
+		interval:  interval,
+		retention: retention,
+	}
+	if err := ring.reload(); err != nil {
+		return nil, err
+	}
+	if len(ring.keys) == 0 {
+		if err := ring.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+// RunKeyRotation reloads the keys other replicas wrote to JWT_KEYS_DIR,
+// rotates the active signing and encryption keys every
+// JWT_KEY_ROTATION_INTERVAL and drops retired keys once their retention
+// window has passed.
+func RunKeyRotation(ctx context.Context) {
+	var rings []*KeyRing
+	if keyRing != nil && keyRing.algorithm != "HS256" {
+		rings = append(rings, keyRing)
+	}
+	if format, ok := tokenFormat.(*jweFormat); ok {
+		rings = append(rings, format.ring)
+	}
+	if len(rings) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, ring := range rings {
+				ring.maintain()
+			}
+		}
+	}
+}
+
+func (k *KeyRing) maintain() {
+	if err := k.reload(); err != nil {
+		log.Println("error reloading", k.algorithm, "keys:", err)
+	}
+	if kid, due := k.rotationDue(); due && k.claimRotation(kid) {
+		if err := k.Rotate(); err != nil {
+			log.Println("error rotating", k.algorithm, "key:", err)
+		}
+	}
+	k.prune()
+}
+
+// reload reads the keys in dir. Keys that are already loaded are kept, keys
+// whose file is gone were pruned by another replica.
+func (k *KeyRing) reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	loaded := map[string]*SigningKey{}
+	for _, key := range k.keys {
+		loaded[key.Kid] = key
+	}
+	k.mu.RUnlock()
+
+	keys := []*SigningKey{}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		if key, ok := loaded[kid]; ok {
+			keys = append(keys, key)
+			continue
+		}
+		key, err := k.readKey(file, kid)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 && len(loaded) > 0 {
+		return fmt.Errorf("no keys left in %s", k.dir)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.order()
+	k.mu.Unlock()
+	k.prune()
+	return nil
+}
+
+// readKey parses a key file. The creation time comes from the Created PEM
+// header, which unlike the modification time survives copying the file;
+// keys written before the header was introduced fall back to the latter.
+func (k *KeyRing) readKey(file string, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	key := &SigningKey{Kid: kid, Algorithm: k.algorithm}
+	if block.Type == secretKeyPEMType {
+		key.Secret = block.Bytes
+	} else {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		key.Private, _ = parsed.(crypto.Signer)
+	}
+	if !k.usable(key) {
+		log.Println("skipping key not usable with", k.algorithm+":", file)
+		return nil, nil
+	}
+
+	var createdAt time.Time
+	if created, ok := block.Headers["Created"]; ok {
+		if createdAt, err = time.Parse(time.RFC3339Nano, created); err != nil {
+			return nil, fmt.Errorf("parsing %s: invalid Created header", file)
+		}
+	} else {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		createdAt = info.ModTime()
+	}
+	key.CreatedAt = createdAt
+	return key, nil
+}
+
+// usable reports whether key can be used with the algorithm of the ring.
+func (k *KeyRing) usable(key *SigningKey) bool {
+	switch k.algorithm {
+	case jweDirect:
+		return len(key.Secret) == jweDirectKeyLen
+	case jweECDHES:
+		return key.Private != nil && algorithmFor(key.Private) == "ES256"
+	}
+	return key.Private != nil && algorithmFor(key.Private) == k.algorithm
+}
+
+// order sorts the keys newest first and sets when each was succeeded as
+// the active key. The caller holds the lock.
+func (k *KeyRing) order() {
+	sort.Slice(k.keys, func(i, j int) bool {
+		return k.keys[i].CreatedAt.After(k.keys[j].CreatedAt)
+	})
+	for i := 1; i < len(k.keys); i++ {
+		k.keys[i].RetiredAt = k.keys[i-1].CreatedAt.Add(k.publishDelay())
+	}
+}
+
+// publishDelay is zero without a shared directory, since no other replica
+// has to learn about new keys then.
+func (k *KeyRing) publishDelay() time.Duration {
+	if k.dir == "" {
+		return 0
+	}
+	return keyPublishDelay
+}
+
+// rotationDue reports whether the newest key is older than the rotation
+// interval, and which key that is.
+func (k *KeyRing) rotationDue() (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	newest := k.keys[0]
+	return newest.Kid, k.interval > 0 && time.Since(newest.CreatedAt) >= k.interval
+}
+
+// claimRotation elects the replica that replaces the key kid: the first to
+// create the <kid>.rotating marker in the shared directory.
+func (k *KeyRing) claimRotation(kid string) bool {
+	if k.dir == "" {
+		return true
+	}
+	marker := filepath.Join(k.dir, kid+".rotating")
+	file, err := os.OpenFile(marker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		// a replica that died while rotating leaves its marker behind
+		if info, statErr := os.Stat(marker); statErr == nil && time.Since(info.ModTime()) > keyPublishDelay {
+			_ = os.Remove(marker)
+		}
+		return false
+	}
+	return file.Close() == nil
+}
+
+// Rotate generates a new key. It signs once it has been published for
+// keyPublishDelay; the previous key is kept for verification until the
+// retention window has passed.
+func (k *KeyRing) Rotate() error {
+	key := &SigningKey{
+		Kid:       NewTokenID()[:16],
+		Algorithm: k.algorithm,
+		CreatedAt: time.Now(),
+	}
+	block := &pem.Block{
+		Type:    secretKeyPEMType,
+		Headers: map[string]string{"Created": key.CreatedAt.UTC().Format(time.RFC3339Nano)},
+	}
+	if k.algorithm == jweDirect {
+		key.Secret = make([]byte, jweDirectKeyLen)
+		if _, err := rand.Read(key.Secret); err != nil {
+			return err
+		}
+		block.Bytes = key.Secret
+	} else {
+		signer, err := generateKey(k.algorithm)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(signer)
+		if err != nil {
+			return err
+		}
+		key.Private = signer
+		block.Type = "PRIVATE KEY"
+		block.Bytes = der
+	}
+
+	if k.dir != "" {
+		data := pem.EncodeToMemory(block)
+		// replicas reloading the directory must never see a partial file
+		file := filepath.Join(k.dir, key.Kid+".pem")
+		if err := os.WriteFile(file+".tmp", data, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return err
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = append([]*SigningKey{key}, k.keys...)
+	k.order()
+	log.Println("rotated", k.algorithm, "key, new kid:", key.Kid)
+	return nil
+}
+
+func (k *KeyRing) prune() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	kept := k.keys[:0]
+	for i, key := range k.keys {
+		if i > 0 && time.Since(key.RetiredAt) > k.retention {
+			if k.dir != "" {
+				// every replica prunes the same keys, since they share
+				// the creation times
+				_ = os.Remove(filepath.Join(k.dir, key.Kid+".pem"))
+				_ = os.Remove(filepath.Join(k.dir, key.Kid+".rotating"))
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	k.keys = kept
+}
+
+// active returns the newest key that has been published for
+// keyPublishDelay, or the oldest key while none has.
+func (k *KeyRing) active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if time.Since(key.CreatedAt) >= k.publishDelay() {
+			return key
+		}
+	}
+	return k.keys[len(k.keys)-1]
+}
+
+func (k *KeyRing) find(kid string) *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// SignClaims signs claims with the active key and sets the kid header.
+func SignClaims(claims jwt.Claims) (string, error) {
+	key := keyRing.active()
+	method, err := signingMethod(key.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.Kid
+	if key.Secret != nil {
+		return token.SignedString(key.Secret)
+	}
+	return token.SignedString(key.Private)
+}
+
+// verificationKey resolves the key for a token by its kid and refuses
+// tokens whose alg header does not match the algorithm of that key.
+func verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := keyRing.find(kid)
+	if key == nil && kid == "" && keyRing.algorithm == "HS256" {
+		// tokens issued before key ids were introduced
+		key = keyRing.active()
+	}
+	if key == nil {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing algorithm")
+	}
+	if key.Secret != nil {
+		return key.Secret, nil
+	}
+	return key.Private.Public(), nil
+}
+
+// SigningAlgorithm returns the JWS algorithm of the active key.
+func SigningAlgorithm() string {
+	return keyRing.algorithm
+}
+
+func validMethods() []string {
+	return []string{keyRing.algorithm}
+}
+
+// PublicJWKS returns the public part of every asymmetric key that is still
+// accepted for verification. Symmetric secrets are never published.
+func PublicJWKS() []map[string]string {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	keys := []map[string]string{}
+	for _, key := range keyRing.keys {
+		if key.Private == nil {
+			continue
+		}
+		jwk := publicJWK(key.Private.Public())
+		jwk["kid"] = key.Kid
+		jwk["alg"] = key.Algorithm
+		jwk["use"] = "sig"
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+func publicJWK(public crypto.PublicKey) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   b64(pub.N.Bytes()),
+			"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": pub.Curve.Params().Name,
+			"x":   b64(pub.X.FillBytes(make([]byte, size))),
+			"y":   b64(pub.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(pub),
+		}
+	}
+	return map[string]string{}
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case "HS256":
+		return jwt.SigningMethodHS256, nil
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "ES256":
+		return jwt.SigningMethodES256, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256", jweECDHES:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+}
+
+func algorithmFor(signer crypto.Signer) string {
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		return "RS256"
+	case *ecdsa.PublicKey:
+		if pub.Curve == elliptic.P256() {
+			return "ES256"
+		}
+	case ed25519.PublicKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
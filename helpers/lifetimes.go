@@ -0,0 +1,132 @@
+package helpers
+
+import (
+	"errors"
+	"go-auth/models"
+	"os"
+	"strings"
+	"time"
+)
+
+// Lifetimes are the effective token lifetimes and session timeouts for one
+// login. A zero Idle or Absolute timeout disables that check.
+type Lifetimes struct {
+	Access   time.Duration
+	Refresh  time.Duration
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+// ImpersonationTokenTTL is deliberately not configurable.
+const ImpersonationTokenTTL = 5 * time.Minute
+
+var (
+	defaultLifetimes = Lifetimes{Access: 7 * time.Minute, Refresh: 168 * time.Hour}
+	roleLifetimes    = map[string]Lifetimes{}
+	resetTokenTTL    = 5 * time.Minute
+	maxTokenLifetime = 720 * time.Hour
+)
+
+// InitLifetimes reads the token lifetimes from the environment:
+// JWT_ACCESS_TTL, JWT_REFRESH_TTL and JWT_RESET_TTL set the global lifetimes,
+// SESSION_IDLE_TIMEOUT and SESSION_ABSOLUTE_TIMEOUT the session timeouts,
+// JWT_ROLE_LIFETIMES overrides them per user type
+// ("ADMIN=access:5m|refresh:8h|idle:30m|absolute:8h,USER=refresh:720h")
+// and JWT_MAX_TTL caps every lifetime, including per-client ones.
+func InitLifetimes() error {
+	var err error
+	if maxTokenLifetime, err = durationFromEnv("JWT_MAX_TTL", maxTokenLifetime); err != nil {
+		return err
+	}
+	if resetTokenTTL, err = durationFromEnv("JWT_RESET_TTL", resetTokenTTL); err != nil {
+		return err
+	}
+	if defaultLifetimes.Access, err = durationFromEnv("JWT_ACCESS_TTL", defaultLifetimes.Access); err != nil {
+		return err
+	}
+	if defaultLifetimes.Refresh, err = durationFromEnv("JWT_REFRESH_TTL", defaultLifetimes.Refresh); err != nil {
+		return err
+	}
+	if defaultLifetimes.Idle, err = durationFromEnv("SESSION_IDLE_TIMEOUT", 0); err != nil {
+		return err
+	}
+	if defaultLifetimes.Absolute, err = durationFromEnv("SESSION_ABSOLUTE_TIMEOUT", 0); err != nil {
+		return err
+	}
+
+	roleLifetimes = map[string]Lifetimes{}
+	if value := os.Getenv("JWT_ROLE_LIFETIMES"); value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			role, settings, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || role == "" || settings == "" {
+				return errors.New("invalid JWT_ROLE_LIFETIMES entry: " + entry)
+			}
+			var lifetimes Lifetimes
+			for _, setting := range strings.Split(settings, "|") {
+				name, raw, _ := strings.Cut(setting, ":")
+				d, err := time.ParseDuration(raw)
+				if err != nil || d <= 0 {
+					return errors.New("invalid JWT_ROLE_LIFETIMES setting: " + setting)
+				}
+				switch name {
+				case "access":
+					lifetimes.Access = d
+				case "refresh":
+					lifetimes.Refresh = d
+				case "idle":
+					lifetimes.Idle = d
+				case "absolute":
+					lifetimes.Absolute = d
+				default:
+					return errors.New("unknown JWT_ROLE_LIFETIMES setting: " + name)
+				}
+			}
+			roleLifetimes[role] = lifetimes
+		}
+	}
+
+	if defaultLifetimes.Access > maxTokenLifetime || defaultLifetimes.Refresh > maxTokenLifetime {
+		return errors.New("JWT_ACCESS_TTL and JWT_REFRESH_TTL must not exceed JWT_MAX_TTL")
+	}
+	return nil
+}
+
+// ResolveLifetimes returns the lifetimes for a user of userType signing in
+// through a client with the given overrides. The role and the client each
+// override the global settings; where both do, the shorter value wins so
+// neither can weaken a restriction set by the other.
+func ResolveLifetimes(userType string, client models.TokenLifetimes) Lifetimes {
+	role := roleLifetimes[userType]
+	seconds := func(n int) time.Duration { return time.Duration(n) * time.Second }
+
+	return Lifetimes{
+		Access:   pickLifetime(defaultLifetimes.Access, role.Access, seconds(client.AccessTokenTTL)),
+		Refresh:  pickLifetime(defaultLifetimes.Refresh, role.Refresh, seconds(client.RefreshTokenTTL)),
+		Idle:     pickLifetime(defaultLifetimes.Idle, role.Idle, seconds(client.IdleTimeout)),
+		Absolute: pickLifetime(defaultLifetimes.Absolute, role.Absolute, seconds(client.AbsoluteTimeout)),
+	}
+}
+
+func pickLifetime(global time.Duration, role time.Duration, client time.Duration) time.Duration {
+	d := global
+	switch {
+	case role > 0 && client > 0:
+		d = min(role, client)
+	case role > 0:
+		d = role
+	case client > 0:
+		d = client
+	}
+	return min(d, maxTokenLifetime)
+}
+
+// MaxTokenLifetime is the longest any token can live, which is how long
+// revocations and retired signing keys have to be kept.
+func MaxTokenLifetime() time.Duration {
+	return maxTokenLifetime
+}
+
+// ResetTokenTTL is the lifetime of password reset tokens.
+func ResetTokenTTL() time.Duration {
+	return resetTokenTTL
+}
@@ -0,0 +1,79 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LockoutConfig controls how failed logins lock accounts and addresses.
+// Every lockout of the same account doubles its duration, up to
+// MaxDuration; failures and lockouts are forgotten after ResetAfter
+// without a failure.
+type LockoutConfig struct {
+	Threshold   int
+	IPThreshold int
+	Duration    time.Duration
+	MaxDuration time.Duration
+	ResetAfter  time.Duration
+}
+
+// UnlockTokenTTL is how long the link in an unlock email can be used.
+const UnlockTokenTTL = time.Hour
+
+var lockoutConfig = LockoutConfig{
+	Threshold:   5,
+	IPThreshold: 20,
+	Duration:    time.Minute,
+	MaxDuration: 24 * time.Hour,
+	ResetAfter:  24 * time.Hour,
+}
+
+// InitLockoutConfig reads LOCKOUT_THRESHOLD, LOCKOUT_IP_THRESHOLD,
+// LOCKOUT_DURATION, LOCKOUT_MAX_DURATION and LOCKOUT_RESET_AFTER.
+func InitLockoutConfig() error {
+	var err error
+	if lockoutConfig.Threshold, err = intFromEnv("LOCKOUT_THRESHOLD", lockoutConfig.Threshold); err != nil {
+		return err
+	}
+	if lockoutConfig.IPThreshold, err = intFromEnv("LOCKOUT_IP_THRESHOLD", lockoutConfig.IPThreshold); err != nil {
+		return err
+	}
+	if lockoutConfig.Duration, err = durationFromEnv("LOCKOUT_DURATION", lockoutConfig.Duration); err != nil {
+		return err
+	}
+	if lockoutConfig.MaxDuration, err = durationFromEnv("LOCKOUT_MAX_DURATION", lockoutConfig.MaxDuration); err != nil {
+		return err
+	}
+	if lockoutConfig.ResetAfter, err = durationFromEnv("LOCKOUT_RESET_AFTER", lockoutConfig.ResetAfter); err != nil {
+		return err
+	}
+	return nil
+}
+
+func Lockout() LockoutConfig {
+	return lockoutConfig
+}
+
+// LockoutDuration returns how long the given lockout, counting from one,
+// lasts.
+func LockoutDuration(lockouts int) time.Duration {
+	d := lockoutConfig.Duration
+	for i := 1; i < lockouts && d < lockoutConfig.MaxDuration; i++ {
+		d *= 2
+	}
+	return min(d, lockoutConfig.MaxDuration)
+}
+
+func intFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %q is not a positive number", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyPKCE checks an S256 code_verifier against the code_challenge sent
+// to the authorization endpoint (RFC 7636).
+func VerifyPKCE(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// OAuthError writes an RFC 6749 error response.
+func OAuthError(c *gin.Context, status int, code string, description string) {
+	c.Header("Cache-Control", "no-store")
+	body := gin.H{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	c.JSON(status, body)
+}
+
+// userCodeAlphabet leaves out vowels and look-alike characters (RFC 8628 section 6.1).
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// NewUserCode returns an 8 character device flow user code. It is stored
+// normalized and shown to people as XXXX-XXXX.
+func NewUserCode() string {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			log.Panic(err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+func FormatUserCode(code string) string {
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// NormalizeUserCode accepts user codes typed in lower case or with separators.
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SupportedUserScopes are the scopes a user login can be granted.
+var SupportedUserScopes = []string{"openid", "profile", "email"}
+
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IDTokenParams describes the user and authentication event an id_token is issued for.
+type IDTokenParams struct {
+	Uid           string
+	Email         string
+	EmailVerified bool
+	Username      string
+	ClientID      string
+	Scope         string
+	Nonce         string
+	AuthTime      time.Time
+	Lifetime      time.Duration // of the access token issued alongside
+}
+
+// GenerateIDToken issues an OpenID Connect id_token. Profile and email
+// claims are only included when their scope was granted.
+func GenerateIDToken(params IDTokenParams) (string, error) {
+	lifetime := params.Lifetime
+	if lifetime == 0 {
+		lifetime = defaultLifetimes.Access
+	}
+	audience := []string{tokenConfig.Audience}
+	if params.ClientID != "" {
+		audience = []string{params.ClientID}
+	}
+
+	claims := &IDTokenClaims{
+		Nonce:            params.Nonce,
+		AuthTime:         params.AuthTime.Unix(),
+		RegisteredClaims: newRegisteredClaims(time.Now(), params.Uid, audience, lifetime),
+	}
+	if HasScope(params.Scope, "email") {
+		claims.Email = params.Email
+		claims.EmailVerified = &params.EmailVerified
+	}
+	if HasScope(params.Scope, "profile") {
+		claims.PreferredUsername = params.Username
+	}
+	return SignClaims(claims)
+}
+
+func HasScope(scope string, wanted string) bool {
+	return slices.Contains(strings.Fields(scope), wanted)
+}
+
+// FilterScope keeps the requested scopes that appear in allowed, in request order.
+func FilterScope(requested string, allowed []string) string {
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if slices.Contains(allowed, scope) && !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " ")
+}
@@ -0,0 +1,287 @@
+package helpers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// PASETO v4 (https://github.com/paseto-standard/paseto-spec). Both purposes
+// have a single fixed algorithm, so there is no header to negotiate.
+const (
+	pasetoPublicHeader = "v4.public."
+	pasetoLocalHeader  = "v4.local."
+	pasetoLocalKid     = "local"
+)
+
+var errInvalidPaseto = errors.New("token is invalid")
+
+// pasetoDateClaims are NumericDates in our claims and RFC 3339 strings in
+// PASETO payloads.
+var pasetoDateClaims = []string{"exp", "nbf", "iat"}
+
+type pasetoFooter struct {
+	Kid string `json:"kid"`
+}
+
+// pasetoPublic signs tokens with the active Ed25519 key of the keyring, so
+// key rotation and the JWKS endpoint work as they do for EdDSA JWTs.
+type pasetoPublic struct{}
+
+func (pasetoPublic) Name() string {
+	return "v4.public"
+}
+
+func (pasetoPublic) Sign(claims *SignedDetails) (string, error) {
+	key := keyRing.active()
+	private, ok := key.Private.(ed25519.PrivateKey)
+	if !ok {
+		return "", errors.New("v4.public tokens need JWT_SIGNING_ALG=EdDSA")
+	}
+	payload, err := pasetoEncodeClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	footer, err := json.Marshal(pasetoFooter{Kid: key.Kid})
+	if err != nil {
+		return "", err
+	}
+
+	return pasetoSign(private, payload, footer), nil
+}
+
+func (pasetoPublic) Parse(token string) (*SignedDetails, error) {
+	body, footer, err := splitPaseto(token, pasetoPublicHeader)
+	if err != nil {
+		return nil, errInvalidPaseto
+	}
+	var f pasetoFooter
+	if err := json.Unmarshal(footer, &f); err != nil {
+		return nil, errInvalidPaseto
+	}
+	key := keyRing.find(f.Kid)
+	if key == nil || key.Private == nil {
+		return nil, errors.New("unknown signing key")
+	}
+	public, ok := key.Private.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("unexpected signing algorithm")
+	}
+
+	payload, err := pasetoVerify(public, body, footer)
+	if err != nil {
+		return nil, err
+	}
+	return pasetoDecodeClaims(payload)
+}
+
+func pasetoSign(private ed25519.PrivateKey, payload []byte, footer []byte) string {
+	signature := ed25519.Sign(private, pae([]byte(pasetoPublicHeader), payload, footer, nil))
+	return pasetoPublicHeader + b64(append(payload, signature...)) + pasetoFooterSuffix(footer)
+}
+
+func pasetoVerify(public ed25519.PublicKey, body []byte, footer []byte) ([]byte, error) {
+	if len(body) < ed25519.SignatureSize {
+		return nil, errInvalidPaseto
+	}
+	payload, signature := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(public, pae([]byte(pasetoPublicHeader), payload, footer, nil), signature) {
+		return nil, errInvalidPaseto
+	}
+	return payload, nil
+}
+
+// pasetoLocal encrypts tokens with XChaCha20 and authenticates them with
+// keyed BLAKE2b. Only holders of the shared key can read or verify them.
+type pasetoLocal struct {
+	key []byte
+}
+
+func (pasetoLocal) Name() string {
+	return "v4.local"
+}
+
+func (p pasetoLocal) Sign(claims *SignedDetails) (string, error) {
+	payload, err := pasetoEncodeClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	footer, err := json.Marshal(pasetoFooter{Kid: pasetoLocalKid})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return p.seal(nonce, payload, footer)
+}
+
+func (p pasetoLocal) Parse(token string) (*SignedDetails, error) {
+	body, footer, err := splitPaseto(token, pasetoLocalHeader)
+	if err != nil {
+		return nil, errInvalidPaseto
+	}
+	payload, err := p.open(body, footer)
+	if err != nil {
+		return nil, err
+	}
+	return pasetoDecodeClaims(payload)
+}
+
+// seal encrypts payload with the given random nonce.
+func (p pasetoLocal) seal(nonce []byte, payload []byte, footer []byte) (string, error) {
+	encryptionKey, counterNonce, authKey := p.splitKeys(nonce)
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(payload))
+	cipher.XORKeyStream(ciphertext, payload)
+
+	tag := blake2bMAC(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, nil))
+	body := append(append(append([]byte{}, nonce...), ciphertext...), tag...)
+	return pasetoLocalHeader + b64(body) + pasetoFooterSuffix(footer), nil
+}
+
+func (p pasetoLocal) open(body []byte, footer []byte) ([]byte, error) {
+	if len(body) < 64 {
+		return nil, errInvalidPaseto
+	}
+	nonce, ciphertext, tag := body[:32], body[32:len(body)-32], body[len(body)-32:]
+
+	encryptionKey, counterNonce, authKey := p.splitKeys(nonce)
+	expected := blake2bMAC(authKey, pae([]byte(pasetoLocalHeader), nonce, ciphertext, footer, nil))
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		return nil, errInvalidPaseto
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(payload, ciphertext)
+	return payload, nil
+}
+
+// splitKeys derives the encryption key, the XChaCha20 nonce and the
+// authentication key for one token from its random nonce.
+func (p pasetoLocal) splitKeys(nonce []byte) ([]byte, []byte, []byte) {
+	h, _ := blake2b.New(56, p.key)
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(nonce)
+	tmp := h.Sum(nil)
+
+	a, _ := blake2b.New(32, p.key)
+	a.Write([]byte("paseto-auth-key-for-aead"))
+	a.Write(nonce)
+	return tmp[:32], tmp[32:], a.Sum(nil)
+}
+
+func pasetoLocalKey(value string) ([]byte, error) {
+	key, err := hex.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("PASETO_LOCAL_KEY must be 32 bytes, hex encoded")
+	}
+	return key, nil
+}
+
+func blake2bMAC(key []byte, message []byte) []byte {
+	h, _ := blake2b.New(32, key)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// pae is the pre-authentication encoding of the PASETO spec.
+func pae(pieces ...[]byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces))&^(1<<63))
+	for _, piece := range pieces {
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(piece))&^(1<<63))
+		out = append(out, piece...)
+	}
+	return out
+}
+
+// pasetoFooterSuffix appends the footer, which is left out when empty.
+func pasetoFooterSuffix(footer []byte) string {
+	if len(footer) == 0 {
+		return ""
+	}
+	return "." + b64(footer)
+}
+
+func splitPaseto(token string, header string) ([]byte, []byte, error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, errInvalidPaseto
+	}
+	encodedBody, encodedFooter, _ := strings.Cut(strings.TrimPrefix(token, header), ".")
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, nil, errInvalidPaseto
+	}
+	footer, err := base64.RawURLEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, nil, errInvalidPaseto
+	}
+	return body, footer, nil
+}
+
+func pasetoEncodeClaims(claims *SignedDetails) ([]byte, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, err
+	}
+	for _, name := range pasetoDateClaims {
+		if seconds, ok := payload[name].(float64); ok {
+			payload[name] = time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return json.Marshal(payload)
+}
+
+func pasetoDecodeClaims(raw []byte) (*SignedDetails, error) {
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, errInvalidPaseto
+	}
+	for _, name := range pasetoDateClaims {
+		value, ok := payload[name].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errInvalidPaseto
+		}
+		payload[name] = t.Unix()
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var claims SignedDetails
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, errInvalidPaseto
+	}
+	return &claims, nil
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,211 @@
+package helpers
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Test vectors from https://github.com/paseto-standard/test-vectors/blob/master/v4.json
+const (
+	pasetoVectorLocalKey  = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	pasetoVectorSecretKey = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774" +
+		"1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	pasetoVectorPublicKey = "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+)
+
+func TestPasetoLocalVectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		nonce   string
+		payload string
+		token   string
+	}{
+		{
+			name:    "4-E-1",
+			nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+			payload: `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+			token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+		},
+		{
+			name:    "4-E-2",
+			nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+			payload: `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+			token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+		},
+	}
+
+	key, _ := hex.DecodeString(pasetoVectorLocalKey)
+	p := pasetoLocal{key: key}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonce, _ := hex.DecodeString(tt.nonce)
+			token, err := p.seal(nonce, []byte(tt.payload), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.token {
+				t.Errorf("seal = %s, want %s", token, tt.token)
+			}
+
+			body, footer, err := splitPaseto(tt.token, pasetoLocalHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := p.open(body, footer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != tt.payload {
+				t.Errorf("open = %s, want %s", payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestPasetoPublicVectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		footer  string
+		token   string
+	}{
+		{
+			name:    "4-S-1",
+			payload: `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+			token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+		},
+		{
+			name:    "4-S-2",
+			payload: `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+			footer:  `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`,
+			token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		},
+	}
+
+	secret, _ := hex.DecodeString(pasetoVectorSecretKey)
+	public, _ := hex.DecodeString(pasetoVectorPublicKey)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := pasetoSign(ed25519.PrivateKey(secret), []byte(tt.payload), []byte(tt.footer))
+			if token != tt.token {
+				t.Errorf("sign = %s, want %s", token, tt.token)
+			}
+
+			body, footer, err := splitPaseto(tt.token, pasetoPublicHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := pasetoVerify(ed25519.PublicKey(public), body, footer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != tt.payload {
+				t.Errorf("verify = %s, want %s", payload, tt.payload)
+			}
+		})
+	}
+}
+
+// withEdDSAKeyRing swaps in a keyring holding a single Ed25519 key.
+func withEdDSAKeyRing(t *testing.T) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := keyRing
+	keyRing = &KeyRing{
+		algorithm: "EdDSA",
+		keys:      []*SigningKey{{Kid: "test", Algorithm: "EdDSA", Private: private, CreatedAt: time.Now()}},
+	}
+	t.Cleanup(func() { keyRing = previous })
+}
+
+func pasetoFormats(t *testing.T) []TokenFormat {
+	withEdDSAKeyRing(t)
+	key, _ := hex.DecodeString(pasetoVectorLocalKey)
+	return []TokenFormat{pasetoPublic{}, pasetoLocal{key: key}}
+}
+
+func testClaims() *SignedDetails {
+	return &SignedDetails{
+		Email: "user@example.com",
+		Roles: []string{"admin"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-1",
+			ID:        "jti-1",
+			IssuedAt:  jwt.NewNumericDate(time.Unix(1700000000, 0)),
+			ExpiresAt: jwt.NewNumericDate(time.Unix(1700000900, 0)),
+		},
+	}
+}
+
+func TestPasetoRoundTrip(t *testing.T) {
+	for _, format := range pasetoFormats(t) {
+		t.Run(format.Name(), func(t *testing.T) {
+			token, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := format.Parse(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testClaims()
+			if claims.Subject != want.Subject || claims.ID != want.ID || claims.Email != want.Email {
+				t.Errorf("Parse = %+v, want %+v", claims, want)
+			}
+			if !claims.ExpiresAt.Equal(want.ExpiresAt.Time) || !claims.IssuedAt.Equal(want.IssuedAt.Time) {
+				t.Errorf("dates = %v %v, want %v %v", claims.IssuedAt, claims.ExpiresAt, want.IssuedAt, want.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestPasetoTampered(t *testing.T) {
+	for _, format := range pasetoFormats(t) {
+		t.Run(format.Name(), func(t *testing.T) {
+			header := format.Name() + "."
+			token, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, footer, err := splitPaseto(token, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range []int{0, len(body) / 2, len(body) - 1} {
+				tampered := append([]byte{}, body...)
+				tampered[i] ^= 1
+				if _, err := format.Parse(header + b64(tampered) + pasetoFooterSuffix(footer)); err == nil {
+					t.Errorf("Parse accepted a token with byte %d flipped", i)
+				}
+			}
+			if _, err := format.Parse(header + b64(body[:len(body)-1]) + pasetoFooterSuffix(footer)); err == nil {
+				t.Error("Parse accepted a truncated token")
+			}
+		})
+	}
+}
+
+func TestPasetoWrongFooter(t *testing.T) {
+	for _, format := range pasetoFormats(t) {
+		t.Run(format.Name(), func(t *testing.T) {
+			token, err := format.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			withoutFooter := token[:strings.LastIndex(token, ".")]
+			for _, footer := range []string{`{"kid":"other"}`, `{"kid":"test","x":1}`, ""} {
+				if _, err := format.Parse(withoutFooter + pasetoFooterSuffix([]byte(footer))); err == nil {
+					t.Errorf("Parse accepted footer %q", footer)
+				}
+			}
+		})
+	}
+}
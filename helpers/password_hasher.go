@@ -0,0 +1,371 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHasher hashes passwords into self-describing strings. Argon2id
+// and scrypt use the PHC string format ($id$params$salt$hash); bcrypt keeps
+// its own $2a$cost$ format, which older accounts are stored in.
+type PasswordHasher interface {
+	// ID is the algorithm identifier at the start of the hash string.
+	ID() string
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, a hash made by
+	// this algorithm with any parameters.
+	Verify(password string, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was made with parameters other
+	// than the configured ones.
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	ErrPasswordIncorrect = errors.New("password is incorrect")
+	errMalformedHash     = errors.New("stored password hash is malformed")
+	errUnsupportedHash   = errors.New("unsupported PASSWORD_HASH")
+)
+
+const passwordSaltLength = 16
+
+// passwordHasher hashes new passwords. The default follows the OWASP
+// recommendation for Argon2id.
+var passwordHasher PasswordHasher = &argon2idHasher{memory: 19456, time: 2, threads: 1, keyLength: 32}
+
+// InitPasswordHasher selects the algorithm for new hashes from
+// PASSWORD_HASH (argon2id, scrypt or bcrypt) and its parameters from
+// PASSWORD_HASH_PARAMS, written like the PHC parameters: "m=19456,t=2,p=1"
+// for Argon2id, "ln=15,r=8,p=1" for scrypt and "cost=12" for bcrypt.
+// Hashes made with other settings are upgraded on the next login.
+// Parameters of another algorithm or out of range are rejected.
+func InitPasswordHasher() error {
+	params, err := parsePHCParams(os.Getenv("PASSWORD_HASH_PARAMS"))
+	if err != nil {
+		return fmt.Errorf("invalid PASSWORD_HASH_PARAMS: %w", err)
+	}
+
+	algorithm := os.Getenv("PASSWORD_HASH")
+	if algorithm == "" {
+		algorithm = "argon2id"
+	}
+	hasher, err := newPasswordHasher(algorithm, params)
+	if errors.Is(err, errUnsupportedHash) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("invalid PASSWORD_HASH_PARAMS: %w", err)
+	}
+	passwordHasher = hasher
+	return nil
+}
+
+func newPasswordHasher(algorithm string, params map[string]int) (PasswordHasher, error) {
+	switch algorithm {
+	case "argon2id":
+		if err := onlyParams(algorithm, params, "m", "t", "p"); err != nil {
+			return nil, err
+		}
+		memory := paramOr(params, "m", 19456)
+		time := paramOr(params, "t", 2)
+		threads := paramOr(params, "p", 1)
+		// RFC 9106 section 3.1: 1 <= p <= 2^24-1 and m >= 8*p, of which
+		// the Go implementation takes p as a uint8
+		if threads > math.MaxUint8 {
+			return nil, fmt.Errorf("p must be between 1 and %d", math.MaxUint8)
+		}
+		if int64(memory) > math.MaxUint32 || memory < 8*threads {
+			return nil, fmt.Errorf("m must be at least 8*p (%d) and at most %d KiB", 8*threads, uint32(math.MaxUint32))
+		}
+		if int64(time) > math.MaxUint32 {
+			return nil, fmt.Errorf("t must be at most %d", uint32(math.MaxUint32))
+		}
+		return &argon2idHasher{memory: uint32(memory), time: uint32(time), threads: uint8(threads), keyLength: 32}, nil
+	case "scrypt":
+		if err := onlyParams(algorithm, params, "ln", "r", "p"); err != nil {
+			return nil, err
+		}
+		h := &scryptHasher{logN: paramOr(params, "ln", 15), r: paramOr(params, "r", 8), p: paramOr(params, "p", 1), keyLength: 32}
+		if h.logN < 10 || h.logN > 24 {
+			return nil, errors.New("ln must be between 10 and 24")
+		}
+		// the limit scrypt.Key enforces, checked before the first login
+		if uint64(h.r)*uint64(h.p) >= 1<<30 {
+			return nil, errors.New("r*p must be less than 2^30")
+		}
+		return h, nil
+	case "bcrypt":
+		if err := onlyParams(algorithm, params, "cost"); err != nil {
+			return nil, err
+		}
+		h := &bcryptHasher{cost: paramOr(params, "cost", 12)}
+		if h.cost < bcrypt.MinCost || h.cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return h, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errUnsupportedHash, algorithm)
+}
+
+// HashPassword hashes password with the configured algorithm.
+func HashPassword(password string) (string, error) {
+	return passwordHasher.Hash(password)
+}
+
+// VerifyPassword checks userPass against providedPass, a stored hash of any
+// supported algorithm. A mismatch returns ErrPasswordIncorrect.
+func VerifyPassword(userPass string, providedPass string) (bool, error) {
+	hasher, err := hasherFor(providedPass)
+	if err != nil {
+		return false, err
+	}
+	ok, err := hasher.Verify(userPass, providedPass)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrPasswordIncorrect
+	}
+	return true, nil
+}
+
+// PasswordNeedsRehash reports whether a stored hash should be replaced by
+// one made with the configured algorithm and parameters.
+func PasswordNeedsRehash(encoded string) bool {
+	hasher, err := hasherFor(encoded)
+	if err != nil || hasher.ID() != passwordHasher.ID() {
+		return true
+	}
+	return passwordHasher.NeedsRehash(encoded)
+}
+
+func hasherFor(encoded string) (PasswordHasher, error) {
+	switch id, _, _ := strings.Cut(strings.TrimPrefix(encoded, "$"), "$"); id {
+	case passwordHasher.ID():
+		return passwordHasher, nil
+	case "argon2id":
+		return &argon2idHasher{}, nil
+	case "scrypt":
+		return &scryptHasher{}, nil
+	case "2a", "2b", "2y":
+		return &bcryptHasher{}, nil
+	}
+	return nil, errMalformedHash
+}
+
+// argon2id
+
+type argon2idHasher struct {
+	memory    uint32
+	time      uint32
+	threads   uint8
+	keyLength uint32
+}
+
+func (h *argon2idHasher) ID() string {
+	return "argon2id"
+}
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.time, h.threads, phcEncode(salt), phcEncode(key)), nil
+}
+
+func (h *argon2idHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, uint32(params["t"]), uint32(params["m"]), uint8(params["p"]), uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	return err != nil || params["m"] != int(h.memory) || params["t"] != int(h.time) ||
+		params["p"] != int(h.threads) || len(key) != int(h.keyLength)
+}
+
+func (h *argon2idHasher) decode(encoded string) (map[string]int, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return nil, nil, nil, errMalformedHash
+	}
+	params, err := parsePHCParams(parts[3])
+	if err != nil || params["m"] <= 0 || params["t"] <= 0 || params["p"] <= 0 || params["p"] > 255 {
+		return nil, nil, nil, errMalformedHash
+	}
+	salt, key, err := phcDecodeSaltAndHash(parts[4], parts[5])
+	return params, salt, key, err
+}
+
+// scrypt
+
+type scryptHasher struct {
+	logN      int
+	r         int
+	p         int
+	keyLength int
+}
+
+func (h *scryptHasher) ID() string {
+	return "scrypt"
+}
+
+func (h *scryptHasher) Hash(password string) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.logN, h.r, h.p, h.keyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.logN, h.r, h.p, phcEncode(salt), phcEncode(key)), nil
+}
+
+func (h *scryptHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other, err := scrypt.Key([]byte(password), salt, 1<<params["ln"], params["r"], params["p"], len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *scryptHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	return err != nil || params["ln"] != h.logN || params["r"] != h.r || params["p"] != h.p || len(key) != h.keyLength
+}
+
+func (h *scryptHasher) decode(encoded string) (map[string]int, []byte, []byte, error) {
+	// "", "scrypt", "ln=..,r=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return nil, nil, nil, errMalformedHash
+	}
+	params, err := parsePHCParams(parts[2])
+	if err != nil || params["ln"] < 1 || params["ln"] > 30 || params["r"] <= 0 || params["p"] <= 0 {
+		return nil, nil, nil, errMalformedHash
+	}
+	salt, key, err := phcDecodeSaltAndHash(parts[3], parts[4])
+	return params, salt, key, err
+}
+
+// bcrypt
+
+// bcryptMaxPasswordBytes is the longest password bcrypt hashes.
+const bcryptMaxPasswordBytes = 72
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h *bcryptHasher) ID() string {
+	return "2a"
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, errMalformedHash
+	}
+	return true, nil
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// PHC helpers
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func phcEncode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func phcDecodeSaltAndHash(salt string, hash string) ([]byte, []byte, error) {
+	s, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil || len(s) == 0 {
+		return nil, nil, errMalformedHash
+	}
+	h, err := base64.RawStdEncoding.DecodeString(hash)
+	if err != nil || len(h) == 0 {
+		return nil, nil, errMalformedHash
+	}
+	return s, h, nil
+}
+
+// parsePHCParams parses "a=1,b=2" into a map.
+func parsePHCParams(value string) (map[string]int, error) {
+	params := map[string]int{}
+	if value == "" {
+		return params, nil
+	}
+	for _, param := range strings.Split(value, ",") {
+		name, raw, ok := strings.Cut(param, "=")
+		n, err := strconv.Atoi(raw)
+		if !ok || name == "" || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid parameter %q", param)
+		}
+		params[name] = n
+	}
+	return params, nil
+}
+
+// onlyParams rejects parameters that do not belong to algorithm, which
+// would otherwise be ignored silently.
+func onlyParams(algorithm string, params map[string]int, names ...string) error {
+	for name := range params {
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("%s takes %s, not %s", algorithm, strings.Join(names, ", "), name)
+		}
+	}
+	return nil
+}
+
+func paramOr(params map[string]int, name string, fallback int) int {
+	if n, ok := params[name]; ok {
+		return n
+	}
+	return fallback
+}
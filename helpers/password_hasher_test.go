@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewPasswordHasherParams(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		params    string
+		wantErr   string
+	}{
+		{algorithm: "argon2id", params: ""},
+		{algorithm: "argon2id", params: "m=65536,t=3,p=4"},
+		{algorithm: "argon2id", params: "m=8,t=1,p=1"},
+		{algorithm: "argon2id", params: "m=16,t=1,p=4", wantErr: "m must be at least 8*p (32)"},
+		{algorithm: "argon2id", params: "p=256", wantErr: "p must be between 1 and 255"},
+		{algorithm: "argon2id", params: "m=4294967296", wantErr: "at most 4294967295 KiB"},
+		{algorithm: "argon2id", params: "t=4294967296", wantErr: "t must be at most"},
+		{algorithm: "argon2id", params: "cost=12", wantErr: "argon2id takes m, t, p, not cost"},
+		{algorithm: "argon2id", params: "ln=15", wantErr: "not ln"},
+		{algorithm: "scrypt", params: "ln=16,r=8,p=2"},
+		{algorithm: "scrypt", params: "m=19456", wantErr: "scrypt takes ln, r, p, not m"},
+		{algorithm: "scrypt", params: "ln=9", wantErr: "ln must be between 10 and 24"},
+		{algorithm: "scrypt", params: "r=65536,p=16384", wantErr: "r*p must be less than 2^30"},
+		{algorithm: "bcrypt", params: "cost=10"},
+		{algorithm: "bcrypt", params: "t=2", wantErr: "bcrypt takes cost, not t"},
+		{algorithm: "bcrypt", params: "cost=32", wantErr: "cost must be between"},
+		{algorithm: "md5", params: "", wantErr: "unsupported PASSWORD_HASH: md5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm+" "+tt.params, func(t *testing.T) {
+			params, err := parsePHCParams(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasher, err := newPasswordHasher(tt.algorithm, params)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("newPasswordHasher: %v", err)
+				}
+				if hasher == nil {
+					t.Fatal("no hasher")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newPasswordHasher error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArgon2idParamsKept(t *testing.T) {
+	params, _ := parsePHCParams("m=65536,t=3,p=4")
+	hasher, err := newPasswordHasher("argon2id", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hasher.(*argon2idHasher)
+	if h.memory != 65536 || h.time != 3 || h.threads != 4 {
+		t.Errorf("hasher = %+v, want m=65536 t=3 p=4", h)
+	}
+}
+
+// withPasswordHasher configures the hasher for new hashes for the test.
+func withPasswordHasher(t *testing.T, algorithm string, params string) {
+	t.Helper()
+	parsed, err := parsePHCParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := newPasswordHasher(algorithm, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := passwordHasher
+	passwordHasher = hasher
+	t.Cleanup(func() { passwordHasher = previous })
+}
+
+// cheap parameters of every algorithm, to keep the tests fast
+var testHashers = []struct {
+	algorithm string
+	params    string
+	prefix    string
+}{
+	{algorithm: "argon2id", params: "m=64,t=1,p=1", prefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+	{algorithm: "scrypt", params: "ln=10,r=8,p=1", prefix: "$scrypt$ln=10,r=8,p=1$"},
+	{algorithm: "bcrypt", params: "cost=4", prefix: "$2a$04$"},
+}
+
+func TestPasswordHashRoundTrip(t *testing.T) {
+	for _, tt := range testHashers {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			withPasswordHasher(t, tt.algorithm, tt.params)
+
+			hash, err := HashPassword("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Errorf("hash = %s, want prefix %s", hash, tt.prefix)
+			}
+			if ok, err := VerifyPassword("correct horse battery staple", hash); !ok || err != nil {
+				t.Errorf("VerifyPassword = %v, %v, want true", ok, err)
+			}
+			if ok, err := VerifyPassword("correct horse battery stapler", hash); ok || err != ErrPasswordIncorrect {
+				t.Errorf("VerifyPassword with a wrong password = %v, %v, want ErrPasswordIncorrect", ok, err)
+			}
+			if PasswordNeedsRehash(hash) {
+				t.Error("PasswordNeedsRehash = true for a hash made with the configured settings")
+			}
+
+			// a second hash of the same password gets its own salt
+			again, err := HashPassword("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again == hash {
+				t.Error("two hashes of the same password are equal")
+			}
+		})
+	}
+}
+
+func TestPasswordVerifiesAcrossAlgorithms(t *testing.T) {
+	var hashes []string
+	for _, tt := range testHashers {
+		withPasswordHasher(t, tt.algorithm, tt.params)
+		hash, err := HashPassword("correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	// hashes of every algorithm still verify after switching to another
+	withPasswordHasher(t, "argon2id", "m=32,t=1,p=1")
+	for _, hash := range hashes {
+		if ok, err := VerifyPassword("correct horse battery staple", hash); !ok || err != nil {
+			t.Errorf("VerifyPassword(%s) = %v, %v, want true", hash, ok, err)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		params    string
+		want      bool
+	}{
+		{name: "same settings", algorithm: "argon2id", params: "m=64,t=1,p=1", want: false},
+		{name: "argon2id memory", algorithm: "argon2id", params: "m=128,t=1,p=1", want: true},
+		{name: "argon2id time", algorithm: "argon2id", params: "m=64,t=2,p=1", want: true},
+		{name: "argon2id threads", algorithm: "argon2id", params: "m=64,t=1,p=2", want: true},
+		{name: "to scrypt", algorithm: "scrypt", params: "ln=10,r=8,p=1", want: true},
+		{name: "to bcrypt", algorithm: "bcrypt", params: "cost=4", want: true},
+	}
+	withPasswordHasher(t, "argon2id", "m=64,t=1,p=1")
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withPasswordHasher(t, tt.algorithm, tt.params)
+			if got := PasswordNeedsRehash(hash); got != tt.want {
+				t.Errorf("PasswordNeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("scrypt ln", func(t *testing.T) {
+		withPasswordHasher(t, "scrypt", "ln=10,r=8,p=1")
+		hash, err := HashPassword("correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		withPasswordHasher(t, "scrypt", "ln=11,r=8,p=1")
+		if !PasswordNeedsRehash(hash) {
+			t.Error("PasswordNeedsRehash = false after raising ln")
+		}
+	})
+
+	t.Run("bcrypt cost", func(t *testing.T) {
+		withPasswordHasher(t, "bcrypt", "cost=4")
+		hash, err := HashPassword("correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		withPasswordHasher(t, "bcrypt", "cost=5")
+		if !PasswordNeedsRehash(hash) {
+			t.Error("PasswordNeedsRehash = false after raising the cost")
+		}
+	})
+}
+
+func TestVerifyPasswordMalformed(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{name: "empty", encoded: ""},
+		{name: "plain text", encoded: "correct horse battery staple"},
+		{name: "unknown algorithm", encoded: "$md5$" + salt + "$" + key},
+		{name: "argon2id missing parts", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{name: "argon2id other version", encoded: "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{name: "argon2id missing parameter", encoded: "$argon2id$v=19$m=64,t=1$" + salt + "$" + key},
+		{name: "argon2id bad parameter", encoded: "$argon2id$v=19$m=64,t=x,p=1$" + salt + "$" + key},
+		{name: "argon2id too many threads", encoded: "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key},
+		{name: "argon2id bad salt", encoded: "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key},
+		{name: "argon2id empty hash", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+		{name: "scrypt missing parts", encoded: "$scrypt$ln=10,r=8,p=1$" + salt},
+		{name: "scrypt ln out of range", encoded: "$scrypt$ln=31,r=8,p=1$" + salt + "$" + key},
+		{name: "scrypt bad hash", encoded: "$scrypt$ln=10,r=8,p=1$" + salt + "$***"},
+		{name: "bcrypt truncated", encoded: "$2a$04$abcdefghijklmnopqrstuv"},
+	}
+	withPasswordHasher(t, "argon2id", "m=64,t=1,p=1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifyPassword("correct horse battery staple", tt.encoded)
+			if ok || err == nil || err == ErrPasswordIncorrect {
+				t.Errorf("VerifyPassword = %v, %v, want a malformed hash error", ok, err)
+			}
+			if !PasswordNeedsRehash(tt.encoded) {
+				t.Error("PasswordNeedsRehash = false for a malformed hash")
+			}
+		})
+	}
+}
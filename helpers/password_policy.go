@@ -0,0 +1,212 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Character classes a password policy can require.
+const (
+	PasswordClassUpper  = "upper"
+	PasswordClassLower  = "lower"
+	PasswordClassDigit  = "digit"
+	PasswordClassSymbol = "symbol"
+)
+
+// PasswordPolicyConfig are the rules new passwords must follow.
+type PasswordPolicyConfig struct {
+	MinLength       int      `json:"min_length"`
+	MaxLength       int      `json:"max_length"`
+	MaxBytes        int      `json:"max_bytes,omitempty"` // set when the hash algorithm limits it
+	RequiredClasses []string `json:"required_classes"`
+	// RejectPersonal rejects passwords containing the username or email.
+	RejectPersonal bool `json:"reject_personal"`
+	RejectCommon   bool `json:"reject_common"`
+	// RejectBreached is set when a breached password filter is loaded.
+	RejectBreached bool `json:"reject_breached"`
+}
+
+// PasswordViolation is one rule a password failed.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password failed, so that clients
+// can show them all at once.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+//go:embed common_passwords.txt
+var embeddedCommonPasswords []byte
+
+var (
+	passwordPolicy = PasswordPolicyConfig{
+		MinLength:       8,
+		MaxLength:       128,
+		RequiredClasses: []string{},
+		RejectPersonal:  true,
+		RejectCommon:    true,
+	}
+	commonPasswords = map[string]bool{}
+)
+
+// InitPasswordPolicy reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH,
+// PASSWORD_REQUIRED_CLASSES (a comma separated subset of upper, lower,
+// digit and symbol) and PASSWORD_COMMON_LIST, a file of additional common
+// passwords, one per line, rejected besides the built-in list. It must run
+// after InitPasswordHasher, whose algorithm may cap the length in bytes.
+func InitPasswordPolicy() error {
+	var err error
+	if passwordPolicy.MinLength, err = intFromEnv("PASSWORD_MIN_LENGTH", passwordPolicy.MinLength); err != nil {
+		return err
+	}
+	if passwordPolicy.MaxLength, err = intFromEnv("PASSWORD_MAX_LENGTH", passwordPolicy.MaxLength); err != nil {
+		return err
+	}
+	if passwordPolicy.MaxLength < passwordPolicy.MinLength {
+		return fmt.Errorf("PASSWORD_MAX_LENGTH %d is shorter than PASSWORD_MIN_LENGTH %d", passwordPolicy.MaxLength, passwordPolicy.MinLength)
+	}
+	// bcrypt only reads the first 72 bytes and refuses to hash longer
+	// passwords, so they must be rejected before they reach it
+	passwordPolicy.MaxBytes = 0
+	if _, ok := passwordHasher.(*bcryptHasher); ok {
+		passwordPolicy.MaxBytes = bcryptMaxPasswordBytes
+	}
+
+	passwordPolicy.RequiredClasses = []string{}
+	if value := os.Getenv("PASSWORD_REQUIRED_CLASSES"); value != "" {
+		for _, class := range strings.Split(value, ",") {
+			class = strings.TrimSpace(class)
+			if !slices.Contains([]string{PasswordClassUpper, PasswordClassLower, PasswordClassDigit, PasswordClassSymbol}, class) {
+				return fmt.Errorf("invalid PASSWORD_REQUIRED_CLASSES: unknown class %q", class)
+			}
+			passwordPolicy.RequiredClasses = append(passwordPolicy.RequiredClasses, class)
+		}
+	}
+
+	commonPasswords = map[string]bool{}
+	loadCommonPasswords(embeddedCommonPasswords)
+	if path := os.Getenv("PASSWORD_COMMON_LIST"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("invalid PASSWORD_COMMON_LIST: %w", err)
+		}
+		loadCommonPasswords(data)
+	}
+	return nil
+}
+
+func loadCommonPasswords(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			commonPasswords[strings.ToLower(password)] = true
+		}
+	}
+}
+
+func PasswordPolicy() PasswordPolicyConfig {
+	return passwordPolicy
+}
+
+// ValidatePassword checks password against the policy. personal holds the
+// username and email of the account. The error is a *PasswordPolicyError.
+func ValidatePassword(password string, personal ...string) error {
+	var violations []PasswordViolation
+	violate := func(rule string, format string, args ...any) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < passwordPolicy.MinLength {
+		violate("min_length", "must be at least %d characters long", passwordPolicy.MinLength)
+	}
+	if length > passwordPolicy.MaxLength {
+		violate("max_length", "must be at most %d characters long", passwordPolicy.MaxLength)
+	}
+	if passwordPolicy.MaxBytes > 0 && len(password) > passwordPolicy.MaxBytes {
+		violate("max_bytes", "must be at most %d bytes long", passwordPolicy.MaxBytes)
+	}
+
+	for _, class := range passwordPolicy.RequiredClasses {
+		if !slices.ContainsFunc([]rune(password), passwordClassCheck(class)) {
+			violate("require_"+class, "must contain %s", passwordClassNames[class])
+		}
+	}
+
+	lower := strings.ToLower(password)
+	if passwordPolicy.RejectPersonal {
+		for _, value := range personalValues(personal) {
+			if strings.Contains(lower, value) {
+				violate("personal_info", "must not contain your username or email")
+				break
+			}
+		}
+	}
+	if passwordPolicy.RejectCommon && commonPasswords[lower] {
+		violate("common", "is too common")
+	}
+	if IsPasswordBreached(password) {
+		violate("breached", "has appeared in a data breach")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+var passwordClassNames = map[string]string{
+	PasswordClassUpper:  "an uppercase letter",
+	PasswordClassLower:  "a lowercase letter",
+	PasswordClassDigit:  "a digit",
+	PasswordClassSymbol: "a symbol",
+}
+
+func passwordClassCheck(class string) func(rune) bool {
+	switch class {
+	case PasswordClassUpper:
+		return unicode.IsUpper
+	case PasswordClassLower:
+		return unicode.IsLower
+	case PasswordClassDigit:
+		return unicode.IsDigit
+	}
+	return func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	}
+}
+
+// personalValues returns the lowercased values a password must not
+// contain: the username, the email and its local part. Values shorter than
+// three characters would reject too much and are skipped.
+func personalValues(personal []string) []string {
+	var values []string
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, ok := strings.Cut(value, "@"); ok && len(local) >= 3 {
+			values = append(values, local)
+		}
+		if len(value) >= 3 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyBcryptMaxBytes(t *testing.T) {
+	previous := passwordPolicy
+	t.Cleanup(func() { passwordPolicy = previous })
+
+	// 40 runes, 80 bytes: within PASSWORD_MAX_LENGTH but past what bcrypt hashes
+	long := strings.Repeat("é", 40)
+
+	tests := []struct {
+		algorithm string
+		params    string
+		wantBytes int
+	}{
+		{algorithm: "argon2id", params: "m=64,t=1,p=1", wantBytes: 0},
+		{algorithm: "bcrypt", params: "cost=4", wantBytes: 72},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			withPasswordHasher(t, tt.algorithm, tt.params)
+			if err := InitPasswordPolicy(); err != nil {
+				t.Fatal(err)
+			}
+			if got := PasswordPolicy().MaxBytes; got != tt.wantBytes {
+				t.Fatalf("MaxBytes = %d, want %d", got, tt.wantBytes)
+			}
+
+			err := ValidatePassword(long)
+			var policyErr *PasswordPolicyError
+			rejected := errors.As(err, &policyErr) && policyErr.Violations[0].Rule == "max_bytes"
+			if rejected != (tt.wantBytes > 0) {
+				t.Fatalf("ValidatePassword = %v, want rejected %v", err, tt.wantBytes > 0)
+			}
+			if !rejected {
+				if _, err := HashPassword(long); err != nil {
+					t.Errorf("HashPassword of an accepted password: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package helpers
+
+import (
+	"go-auth/models"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// policyVariables are the names policy conditions can refer to.
+var policyVariables = []string{"subject", "resource", "request"}
+
+var (
+	policyFile           string
+	policyReloadInterval = 30 * time.Second
+	// policyLocation is the time zone of request.time, so that policies can
+	// speak of business hours.
+	policyLocation = time.UTC
+)
+
+// InitPolicyConfig reads where policies are loaded from. POLICY_FILE names
+// a JSON file of policies; without it they are read from MongoDB.
+func InitPolicyConfig() error {
+	var err error
+	policyFile = os.Getenv("POLICY_FILE")
+	if policyReloadInterval, err = durationFromEnv("POLICY_RELOAD_INTERVAL", policyReloadInterval); err != nil {
+		return err
+	}
+	if zone := os.Getenv("POLICY_TIMEZONE"); zone != "" {
+		if policyLocation, err = time.LoadLocation(zone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func PolicyFile() string {
+	return policyFile
+}
+
+func PolicyReloadInterval() time.Duration {
+	return policyReloadInterval
+}
+
+// CompilePolicyCondition compiles the condition of a policy.
+func CompilePolicyCondition(condition string) (*Expression, error) {
+	return CompileExpression(condition, policyVariables...)
+}
+
+// NewPolicyInput collects the attributes of the authenticated subject and
+// the request. resource is nil for checks that do not target a resource.
+func NewPolicyInput(c *gin.Context, action string, resource map[string]any) models.PolicyInput {
+	return models.PolicyInput{
+		Action:   action,
+		Subject:  PolicySubject(c),
+		Resource: resource,
+		Request:  PolicyRequest(c),
+	}
+}
+
+// PolicySubject returns the claims of the access token set by Authenticate.
+func PolicySubject(c *gin.Context) map[string]any {
+	attributes := map[string]any{}
+	if attrs, ok := c.Get("attributes"); ok {
+		if m, ok := attrs.(map[string]string); ok {
+			for k, v := range m {
+				attributes[k] = v
+			}
+		}
+	}
+	return map[string]any{
+		"user_id":     c.GetString("uid"),
+		"principal":   c.GetString("principal"),
+		"email":       c.GetString("email"),
+		"username":    c.GetString("username"),
+		"client_id":   c.GetString("client_id"),
+		"scope":       strings.Fields(c.GetString("scope")),
+		"roles":       c.GetStringSlice("roles"),
+		"permissions": c.GetStringSlice("permissions"),
+		"attributes":  attributes,
+		"actor":       c.GetString("actor"),
+	}
+}
+
+// PolicyRequest describes the request being authorized.
+func PolicyRequest(c *gin.Context) map[string]any {
+	now := time.Now().In(policyLocation)
+	return map[string]any{
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+		"ip":     c.ClientIP(),
+		"time": map[string]any{
+			"unix":    now.Unix(),
+			"date":    now.Format(time.DateOnly),
+			"hour":    now.Hour(),
+			"minute":  now.Minute(),
+			"weekday": int(now.Weekday()),
+		},
+	}
+}
+
+// PolicyResource returns the attributes of a user account as a policy
+// resource. The password hash is never exposed to policies.
+func PolicyResource(user *models.User) map[string]any {
+	attributes := map[string]any{}
+	for k, v := range user.Attributes {
+		attributes[k] = v
+	}
+	resource := map[string]any{
+		"type":           "user",
+		"user_id":        user.User_id,
+		"roles":          user.Roles,
+		"attributes":     attributes,
+		"email_verified": user.Email_verified,
+		"created_at":     user.Created_at.Unix(),
+	}
+	if user.Email != nil {
+		resource["email"] = *user.Email
+	}
+	if user.Username != nil {
+		resource["username"] = *user.Username
+	}
+	if user.User_type != nil {
+		resource["user_type"] = *user.User_type
+	}
+	return resource
+}
+
+// PolicyGrant names what grants the action of input without any policy:
+// the subject owning the resource, or a permission for the action. It is
+// empty when neither does.
+func PolicyGrant(input models.PolicyInput) string {
+	if uid, _ := input.Subject["user_id"].(string); uid != "" && input.Resource != nil && input.Resource["user_id"] == uid {
+		return "ownership of the account"
+	}
+	var permissions []string
+	switch v := input.Subject["permissions"].(type) {
+	case []string:
+		permissions = v
+	case []any:
+		for _, p := range v {
+			if s, ok := p.(string); ok {
+				permissions = append(permissions, s)
+			}
+		}
+	}
+	if slices.Contains(permissions, input.Action) {
+		return "permission " + input.Action
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permissions that routes can require. Roles grant a subset of them.
+const (
+	PermUsersRead        = "users:read"
+	PermUsersWrite       = "users:write"
+	PermUsersDelete      = "users:delete"
+	PermUsersImpersonate = "users:impersonate"
+	PermSessionsRead     = "sessions:read"
+	PermSessionsRevoke   = "sessions:revoke"
+	PermTokensRevoke     = "tokens:revoke"
+	PermClientsWrite     = "clients:write"
+	PermRolesRead        = "roles:read"
+	PermRolesWrite       = "roles:write"
+	PermPoliciesRead     = "policies:read"
+)
+
+// AllPermissions lists every permission in the order they are documented.
+var AllPermissions = []string{
+	PermUsersRead,
+	PermUsersWrite,
+	PermUsersDelete,
+	PermUsersImpersonate,
+	PermSessionsRead,
+	PermSessionsRevoke,
+	PermTokensRevoke,
+	PermClientsWrite,
+	PermRolesRead,
+	PermRolesWrite,
+	PermPoliciesRead,
+}
+
+// Seeded roles. The old ADMIN and USER user types migrate to them.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+var errForbidden = errors.New("unauthorized to access this resource")
+
+func IsKnownPermission(permission string) bool {
+	return slices.Contains(AllPermissions, permission)
+}
+
+// HasPermission reports whether the access token of the request grants permission.
+func HasPermission(c *gin.Context, permission string) bool {
+	return slices.Contains(c.GetStringSlice("permissions"), permission)
+}
+
+func CheckPermission(c *gin.Context, permission string) error {
+	if !HasPermission(c, permission) {
+		return errForbidden
+	}
+	return nil
+}
+
+// MatchUidOrPermission lets users act on their own account, and anyone
+// holding permission act on every account.
+func MatchUidOrPermission(c *gin.Context, userId string, permission string) error {
+	if uid := c.GetString("uid"); uid != "" && uid == userId {
+		return nil
+	}
+	return CheckPermission(c, permission)
+}
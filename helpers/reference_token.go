@@ -0,0 +1,136 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"go-auth/models"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// referenceCacheTTL bounds how long a replica serves claims from memory
+	// before looking at Mongo again.
+	referenceCacheTTL     = time.Minute
+	referenceStoreTimeout = 5 * time.Second
+)
+
+var errUnknownReferenceToken = errors.New("token is unknown or expired")
+
+type cachedClaims struct {
+	claims    SignedDetails
+	expiresAt time.Time
+}
+
+// referenceFormat issues random opaque tokens and keeps their claims on the
+// server, so tokens reveal nothing to whoever holds them. Resource servers
+// resolve them through the introspection endpoint.
+type referenceFormat struct {
+	collection *mongo.Collection
+
+	mu        sync.Mutex
+	cache     map[string]cachedClaims
+	lastSweep time.Time
+}
+
+func newReferenceFormat(collection *mongo.Collection) *referenceFormat {
+	return &referenceFormat{collection: collection, cache: map[string]cachedClaims{}}
+}
+
+func (r *referenceFormat) Name() string {
+	return "opaque"
+}
+
+func (r *referenceFormat) Sign(claims *SignedDetails) (string, error) {
+	if claims.ExpiresAt == nil {
+		return "", errors.New("reference tokens must expire")
+	}
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := b64(secret)
+
+	ctx, cancel := context.WithTimeout(context.Background(), referenceStoreTimeout)
+	defer cancel()
+	record := models.ReferenceToken{
+		TokenHash: HashToken(token),
+		Claims:    string(raw),
+		ExpiresAt: claims.ExpiresAt.Time,
+		CreatedAt: time.Now(),
+	}
+	if _, err := r.collection.InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+
+	r.remember(record.TokenHash, *claims)
+	return token, nil
+}
+
+func (r *referenceFormat) Parse(token string) (*SignedDetails, error) {
+	hash := HashToken(token)
+	if claims, ok := r.lookup(hash); ok {
+		return &claims, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), referenceStoreTimeout)
+	defer cancel()
+	var record models.ReferenceToken
+	err := r.collection.FindOne(ctx, bson.M{
+		"token_hash": hash,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownReferenceToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var claims SignedDetails
+	if err := json.Unmarshal([]byte(record.Claims), &claims); err != nil {
+		return nil, err
+	}
+	r.remember(hash, claims)
+	return &claims, nil
+}
+
+func (r *referenceFormat) lookup(hash string) (SignedDetails, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.cache[hash]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return SignedDetails{}, false
+	}
+	return entry.claims, true
+}
+
+func (r *referenceFormat) remember(hash string, claims SignedDetails) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastSweep) > referenceCacheTTL {
+		for key, entry := range r.cache {
+			if now.After(entry.expiresAt) {
+				delete(r.cache, key)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	expiresAt := now.Add(referenceCacheTTL)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	r.cache[hash] = cachedClaims{claims: claims, expiresAt: expiresAt}
+}
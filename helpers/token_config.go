@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenConfig struct {
+	Issuer          string
+	Audience        string
+	ClientAudiences map[string][]string
+	Leeway          time.Duration
+	PublicURL       string
+}
+
+var tokenConfig = TokenConfig{Issuer: "go-auth", Audience: "go-auth", Leeway: 30 * time.Second}
+
+// InitTokenConfig reads the registered claim settings from the environment:
+// JWT_ISSUER, JWT_AUDIENCE (the audience of this API), JWT_CLIENT_AUDIENCES
+// ("client=aud1|aud2,other=aud3") and JWT_LEEWAY.
+func InitTokenConfig() error {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		tokenConfig.Issuer = issuer
+	}
+	tokenConfig.Audience = tokenConfig.Issuer
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		tokenConfig.Audience = audience
+	}
+
+	tokenConfig.ClientAudiences = map[string][]string{}
+	if value := os.Getenv("JWT_CLIENT_AUDIENCES"); value != "" {
+		for _, entry := range strings.Split(value, ",") {
+			client, audiences, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || client == "" || audiences == "" {
+				return errors.New("invalid JWT_CLIENT_AUDIENCES entry: " + entry)
+			}
+			tokenConfig.ClientAudiences[client] = strings.Split(audiences, "|")
+		}
+	}
+
+	leeway, err := durationFromEnv("JWT_LEEWAY", tokenConfig.Leeway)
+	if err != nil {
+		return err
+	}
+	tokenConfig.Leeway = leeway
+
+	tokenConfig.PublicURL = strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if tokenConfig.PublicURL == "" && strings.HasPrefix(tokenConfig.Issuer, "http") {
+		tokenConfig.PublicURL = strings.TrimSuffix(tokenConfig.Issuer, "/")
+	}
+	return nil
+}
+
+func Issuer() string {
+	return tokenConfig.Issuer
+}
+
+// PublicURL returns the externally visible base URL of the service, falling
+// back to the host of the current request when PUBLIC_BASE_URL is not set.
+func PublicURL(c *gin.Context) string {
+	if tokenConfig.PublicURL != "" {
+		return tokenConfig.PublicURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// EmailBaseURL returns PUBLIC_BASE_URL for links sent by email. Unlike
+// PublicURL it does not fall back to the Host header, which the requester
+// controls and could point the link of someone else's email at their host.
+func EmailBaseURL() (string, error) {
+	if tokenConfig.PublicURL == "" {
+		return "", errors.New("PUBLIC_BASE_URL must be set to send links by email")
+	}
+	return tokenConfig.PublicURL, nil
+}
+
+// audienceFor returns the audiences of a token issued to clientId. The API of
+// this service is always included so the token can be used against it.
+func audienceFor(clientId string) []string {
+	return append([]string{tokenConfig.Audience}, tokenConfig.ClientAudiences[clientId]...)
+}
@@ -2,9 +2,14 @@ package helpers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"go-auth/database"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,43 +19,110 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// SignedDetails carries the user ID in the standard sub claim.
 type SignedDetails struct {
-	Email     string
-	Username  string
-	TokenType string
-	Uid       string
-	User_type string
+	Email       string
+	Username    string
+	TokenType   string
+	User_type   string
+	ClientID    string   `json:"client_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	SubjectType string   `json:"sub_type,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Attributes are the ABAC attributes of the subject, read by policies.
+	Attributes map[string]string `json:"attrs,omitempty"`
+	Act        *Actor            `json:"act,omitempty"`
+	Cnf        *Confirmation     `json:"cnf,omitempty"`
+	IssuedAtMs int64             `json:"iat_ms,omitempty"` // iat in milliseconds, for revocation cutoffs
 	jwt.RegisteredClaims
 }
 
-var userCollection *mongo.Collection = database.OpenCollection(database.DBConnect(), "user")
+// IssuedAtMillis returns when the token was issued in Unix milliseconds.
+// Tokens issued before the iat_ms claim existed fall back to the start of
+// their iat second, and tokens without an iat to zero.
+func (claims *SignedDetails) IssuedAtMillis() int64 {
+	if claims.IssuedAtMs != 0 {
+		return claims.IssuedAtMs
+	}
+	if claims.IssuedAt == nil {
+		return 0
+	}
+	return claims.IssuedAt.Unix() * 1000
+}
+
+// Actor is the RFC 8693 act claim naming who is acting on behalf of the subject.
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+const SubjectTypeClient = "client"
+
+// TokenParams describes who a token pair is issued to.
+type TokenParams struct {
+	Email       string
+	Username    string
+	UserType    string
+	Uid         string
+	ClientID    string
+	Scope       string
+	JKT         string
+	Roles       []string
+	Permissions []string
+	Attributes  map[string]string
+	// SessionID ties both tokens to the login session they were issued for.
+	SessionID string
+	// Lifetimes falls back to the global settings when left empty.
+	Lifetimes Lifetimes
+}
+
+// userCollection connects on first use, so the helpers can be used and
+// tested without a database.
+var userCollection = sync.OnceValue(func() *mongo.Collection {
+	return database.OpenCollection(database.DBConnect(), "user")
+})
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
-func GenerateAllTokens(email string, username string, userType string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func GenerateAllTokens(params TokenParams) (signedToken string, signedRefreshToken string, err error) {
+	lifetimes := params.Lifetimes
+	if lifetimes.Access == 0 || lifetimes.Refresh == 0 {
+		lifetimes = defaultLifetimes
+	}
+
+	now := time.Now()
 	claims := &SignedDetails{
-		Email:     email,
-		Username:  username,
-		TokenType: "access",
-		User_type: userType,
-		Uid:       uid,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * time.Minute)),
-		},
+		Email:            params.Email,
+		Username:         params.Username,
+		TokenType:        "access",
+		User_type:        params.UserType,
+		ClientID:         params.ClientID,
+		Scope:            params.Scope,
+		SessionID:        params.SessionID,
+		Roles:            params.Roles,
+		Permissions:      params.Permissions,
+		Attributes:       params.Attributes,
+		Cnf:              confirmation(params.JKT),
+		IssuedAtMs:       now.UnixMilli(),
+		RegisteredClaims: newRegisteredClaims(now, params.Uid, audienceFor(params.ClientID), lifetimes.Access),
 	}
 
+	// refresh tokens are only ever presented back to this service
 	refreshClaims := &SignedDetails{
-		Uid: uid,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(168 * time.Hour)),
-		},
+		TokenType:        "refresh",
+		ClientID:         params.ClientID,
+		SessionID:        params.SessionID,
+		IssuedAtMs:       now.UnixMilli(),
+		RegisteredClaims: newRegisteredClaims(now, params.Uid, audienceFor(""), lifetimes.Refresh),
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := signToken(claims)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	refreshToken, err := signToken(refreshClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -66,7 +138,7 @@ func UpdateAllToken(c context.Context, signedToken string, signedRefreshToken st
 	filter := bson.M{"user_id": userId}
 	opt := options.Update().SetUpsert(false)
 
-	_, err := userCollection.UpdateOne(
+	_, err := userCollection().UpdateOne(
 		c, filter, bson.D{
 			{Key: "$set", Value: updateObj},
 		},
@@ -79,46 +151,119 @@ func UpdateAllToken(c context.Context, signedToken string, signedRefreshToken st
 	}
 }
 
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&SignedDetails{},
-		func(token *jwt.Token) (any, error) {
-			return []byte(SECRET_KEY), nil
-		},
-	)
+// GenerateClientToken issues an access token to a machine client. The
+// subject is the client itself, so the token carries no user ID.
+func GenerateClientToken(clientId string, scope string, jkt string, ttl time.Duration) (signedToken string, err error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		TokenType:        "access",
+		ClientID:         clientId,
+		Scope:            scope,
+		SubjectType:      SubjectTypeClient,
+		Cnf:              confirmation(jkt),
+		IssuedAtMs:       now.UnixMilli(),
+		RegisteredClaims: newRegisteredClaims(now, clientId, audienceFor(clientId), ttl),
+	}
+	return signToken(claims)
+}
+
+// GenerateImpersonationToken issues a short lived access token for the user
+// in params with an act claim naming the actor. No refresh token is issued.
+func GenerateImpersonationToken(params TokenParams, actor Actor) (signedToken string, err error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Email:            params.Email,
+		Username:         params.Username,
+		TokenType:        "access",
+		User_type:        params.UserType,
+		ClientID:         params.ClientID,
+		Scope:            params.Scope,
+		Roles:            params.Roles,
+		Permissions:      params.Permissions,
+		Attributes:       params.Attributes,
+		Act:              &actor,
+		Cnf:              confirmation(params.JKT),
+		IssuedAtMs:       now.UnixMilli(),
+		RegisteredClaims: newRegisteredClaims(now, params.Uid, audienceFor(params.ClientID), ImpersonationTokenTTL),
+	}
+	return signToken(claims)
+}
 
+// ValidateToken parses a token in the configured format and validates its
+// registered claims.
+func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, err := tokenFormat.Parse(signedToken)
 	if err != nil {
 		msg = "error"
 		return
 	}
 
-	claims, ok := token.Claims.(*SignedDetails)
+	err = jwt.NewValidator(
+		jwt.WithIssuer(tokenConfig.Issuer),
+		jwt.WithAudience(tokenConfig.Audience),
+		jwt.WithLeeway(tokenConfig.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	).Validate(claims)
 
-	if !ok {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		msg = "token is expired"
+		return nil, msg
+	}
+	if err != nil {
 		msg = "token is invalid"
-		return
+		return nil, msg
 	}
 
-	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now().Local()) {
-		msg = "token is expired"
-		return
-	}
 	return claims, msg
 }
 
 func GenerateResetToken(email string) (resetToken string, err error) {
+	now := time.Now()
 	resetclaims := &SignedDetails{
-		Email:     email,
-		TokenType: "reset",
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
-		},
+		Email:            email,
+		TokenType:        "reset",
+		IssuedAtMs:       now.UnixMilli(),
+		RegisteredClaims: newRegisteredClaims(now, "", audienceFor(""), resetTokenTTL),
 	}
 
-	resetToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, resetclaims).SignedString([]byte(SECRET_KEY))
+	resetToken, err = signToken(resetclaims)
 	if err != nil {
 		return "", err
 	}
 	return resetToken, nil
 }
+
+func confirmation(jkt string) *Confirmation {
+	if jkt == "" {
+		return nil
+	}
+	return &Confirmation{JKT: jkt}
+}
+
+func newRegisteredClaims(now time.Time, subject string, audience []string, ttl time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		ID:        NewTokenID(),
+		Issuer:    tokenConfig.Issuer,
+		Subject:   subject,
+		Audience:  audience,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
+
+// HashToken returns the hex encoded SHA-256 digest used to store tokens at rest.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewTokenID returns a random identifier so that no two issued tokens are identical.
+func NewTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
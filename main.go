@@ -4,12 +4,15 @@ import (
 	"context"
 	"go-auth/controllers"
 	"go-auth/database"
+	"go-auth/helpers"
 	"go-auth/routes"
 	"go-auth/services"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
@@ -17,23 +20,128 @@ func main() {
 	client := database.DBConnect()
 	defer client.Disconnect(ctx)
 
-	userCollectionName := os.Getenv("MONGO_USER_COLLECTION")
-	otpCollectionName := os.Getenv("MONGO_OTP_COLLECTION")
-	if userCollectionName == "" || otpCollectionName == "" {
-		log.Fatal("MongoDB collection names not set in environment variables")
+	if err := helpers.InitTokenConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitLifetimes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitPolicyConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitLockoutConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitPasswordHasher(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitPasswordPolicy(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitBreachFilter(); err != nil {
+		log.Fatal(err)
 	}
 
-	usercollection := database.OpenCollection(client, userCollectionName)
-	otpcollection := database.OpenCollection(client, otpCollectionName)
+	usercollection := openCollection(client, "MONGO_USER_COLLECTION")
+	otpcollection := openCollection(client, "MONGO_OTP_COLLECTION")
+	refreshcollection := openCollection(client, "MONGO_REFRESH_TOKEN_COLLECTION")
+	revokedcollection := openCollection(client, "MONGO_REVOKED_TOKEN_COLLECTION")
+	clientcollection := openCollection(client, "MONGO_CLIENT_COLLECTION")
+	codecollection := openCollection(client, "MONGO_AUTH_CODE_COLLECTION")
+	devicecollection := openCollection(client, "MONGO_DEVICE_CODE_COLLECTION")
+	sessioncollection := openCollection(client, "MONGO_SESSION_COLLECTION")
+	referencecollection := openCollection(client, "MONGO_REFERENCE_TOKEN_COLLECTION")
+	rolecollection := openCollection(client, "MONGO_ROLE_COLLECTION")
+	lockoutcollection := openCollection(client, "MONGO_LOCKOUT_COLLECTION")
+	dpopcollection := openCollection(client, "MONGO_DPOP_PROOF_COLLECTION")
+	database.EnsureTTLIndex(refreshcollection, "expires_at")
+	database.EnsureTTLIndex(revokedcollection, "expires_at")
+	database.EnsureTTLIndex(codecollection, "expires_at")
+	database.EnsureTTLIndex(devicecollection, "expires_at")
+	database.EnsureUniqueIndex(devicecollection, "user_code")
+	database.EnsureTTLIndex(sessioncollection, "expires_at")
+	database.EnsureTTLIndex(referencecollection, "expires_at")
+	database.EnsureTTLIndex(lockoutcollection, "expires_at")
+	database.EnsureTTLIndex(dpopcollection, "expires_at")
+	helpers.InitDPoP(dpopcollection)
 
-	userservice := services.NewUserService(usercollection, otpcollection)
-	usercontroller := controllers.NewUserController(userservice)
+	if err := helpers.InitTokenFormat(referencecollection); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.InitKeyRing(); err != nil {
+		log.Fatal(err)
+	}
+	go helpers.RunKeyRotation(ctx)
+
+	tokenservice := services.NewTokenService(refreshcollection, revokedcollection, codecollection, devicecollection, sessioncollection)
+	roleservice := services.NewRoleService(rolecollection, usercollection, tokenservice)
+	if err := roleservice.SeedRoles(ctx); err != nil {
+		log.Fatal(err)
+	}
+	lockoutservice := services.NewLockoutService(lockoutcollection)
+	clientservice := services.NewClientService(clientcollection)
+	userservice := services.NewUserService(usercollection, otpcollection, tokenservice, roleservice, lockoutservice, clientservice)
+
+	// counters are shared through MongoDB when several replicas run
+	ratelimitservice := services.NewMemoryRateLimitService()
+	if os.Getenv("RATE_LIMIT_STORE") == "mongo" {
+		ratelimitcollection := openCollection(client, "MONGO_RATE_LIMIT_COLLECTION")
+		database.EnsureTTLIndex(ratelimitcollection, "expires_at")
+		ratelimitservice = services.NewRateLimitService(ratelimitcollection)
+	}
+
+	// policies come from POLICY_FILE when set and from MongoDB otherwise
+	var policycollection *mongo.Collection
+	if helpers.PolicyFile() == "" {
+		policycollection = openCollection(client, "MONGO_POLICY_COLLECTION")
+	}
+	policyservice := services.NewPolicyService(policycollection, helpers.PolicyFile(), helpers.PolicyReloadInterval())
+	if err := policyservice.LoadPolicies(ctx); err != nil {
+		log.Fatal(err)
+	}
+	go policyservice.WatchPolicies(ctx)
+
+	usercontroller := controllers.NewUserController(userservice, tokenservice, policyservice, lockoutservice)
+	clientcontroller := controllers.NewClientController(clientservice)
+	oauthcontroller := controllers.NewOAuthController(userservice, tokenservice, clientservice, roleservice)
+	rolecontroller := controllers.NewRoleController(roleservice)
+	policycontroller := controllers.NewPolicyController(policyservice, userservice)
 
 	server := gin.Default()
+	// rate limits key on the client IP, which must not be taken from
+	// X-Forwarded-For headers that clients can set themselves
+	if err := server.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal(err)
+	}
+	routes.WellKnownRoutes(&server.RouterGroup)
 	basepath := server.Group("/v1")
-	routes.AuthRoutes(basepath, &usercontroller)
-	routes.UserRoutes(basepath, &usercontroller)
+	routes.AuthRoutes(basepath, &usercontroller, tokenservice, ratelimitservice)
+	routes.UserRoutes(basepath, &usercontroller, tokenservice, policyservice, ratelimitservice)
+	routes.ClientRoutes(basepath, &clientcontroller, tokenservice)
+	routes.OAuthRoutes(basepath, &oauthcontroller, ratelimitservice)
+	routes.RoleRoutes(basepath, &rolecontroller, tokenservice)
+	routes.PolicyRoutes(basepath, &policycontroller, tokenservice)
 
 	log.Println("Server running on :9090")
 	log.Fatal(server.Run(":9090"))
 }
+
+func openCollection(client *mongo.Client, envName string) *mongo.Collection {
+	collectionName := os.Getenv(envName)
+	if collectionName == "" {
+		log.Fatal("MongoDB collection name not set in environment variable ", envName)
+	}
+	return database.OpenCollection(client, collectionName)
+}
+
+// trustedProxies reads TRUSTED_PROXIES, a comma separated list of the
+// addresses or CIDR ranges of the proxies in front of the service.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
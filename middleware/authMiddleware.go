@@ -2,37 +2,86 @@ package middleware
 
 import (
 	"go-auth/helpers"
+	"go-auth/services"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Authenticate() gin.HandlerFunc {
+// Authenticate accepts an access token from "Authorization: Bearer" or
+// "Authorization: DPoP", and from the legacy token header unless
+// ALLOW_LEGACY_TOKEN_HEADER is false. Failures are answered with 401 and a
+// WWW-Authenticate challenge as described by RFC 6750.
+func Authenticate(tokenservice services.TokenService) gin.HandlerFunc {
+	allowLegacy := legacyTokenHeaderAllowed()
 	return func(c *gin.Context) {
-		clientToken := c.Request.Header.Get("token")
-		if clientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "no authorization header provided"})
-			c.Abort()
+		clientToken, scheme, ok := requestToken(c, allowLegacy)
+		if !ok {
 			return
 		}
 		claims, err := helpers.ValidateToken(clientToken)
-
 		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
+			validationFailure(c, scheme, err)
 			return
 		}
 
 		if claims.TokenType != "access" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token type"})
+			challenge(c, scheme, http.StatusUnauthorized, errInvalidToken, "not an access token")
+			return
+		}
+
+		// a DPoP bound token is only usable with a fresh proof from its key
+		if claims.Cnf != nil || scheme == "DPoP" {
+			if claims.Cnf == nil || scheme != "DPoP" {
+				challenge(c, "DPoP", http.StatusUnauthorized, errInvalidToken, "the token must be sent with the DPoP authorization scheme")
+				return
+			}
+			jkt, proofErr := helpers.VerifyDPoPProof(c.Request.Context(), c.GetHeader("DPoP"), c.Request.Method, helpers.RequestURL(c), clientToken)
+			if proofErr != nil || jkt != claims.Cnf.JKT {
+				challenge(c, "DPoP", http.StatusUnauthorized, "invalid_dpop_proof", helpers.ErrInvalidDPoPProof.Error())
+				return
+			}
+		}
+
+		revoked, revokeErr := tokenservice.IsRevoked(c.Request.Context(), claims)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": revokeErr.Error()})
 			c.Abort()
 			return
 		}
+		if revoked {
+			challenge(c, scheme, http.StatusUnauthorized, errInvalidToken, "the token has been revoked")
+			return
+		}
 
+		c.Set("auth_scheme", scheme)
+		if claims.SubjectType == helpers.SubjectTypeClient {
+			c.Set("principal", helpers.SubjectTypeClient)
+		} else {
+			c.Set("principal", "user")
+			c.Set("uid", claims.Subject)
+		}
 		c.Set("email", claims.Email)
 		c.Set("username", claims.Username)
-		c.Set("uid", claims.Uid)
+		c.Set("client_id", claims.ClientID)
+		c.Set("scope", claims.Scope)
 		c.Set("user_type", claims.User_type)
+		c.Set("roles", claims.Roles)
+		c.Set("permissions", claims.Permissions)
+		c.Set("attributes", claims.Attributes)
+		c.Set("jti", claims.ID)
+		c.Set("sid", claims.SessionID)
+		c.Set("expires_at", claims.ExpiresAt.Time)
+
+		if claims.Act == nil {
+			c.Next()
+			return
+		}
+
+		c.Set("actor", claims.Act.Subject)
 		c.Next()
+		log.Printf("audit: impersonated request actor=%s subject=%s jti=%s method=%s path=%s status=%d ip=%s",
+			claims.Act.Subject, claims.Subject, claims.ID, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.ClientIP())
 	}
 }
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"fmt"
+	"go-auth/helpers"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RFC 6750 error codes.
+const (
+	errInvalidRequest    = "invalid_request"
+	errInvalidToken      = "invalid_token"
+	errInsufficientScope = "insufficient_scope"
+)
+
+// legacyTokenHeaderAllowed reports whether tokens may still be sent in the
+// non-standard "token" header. ALLOW_LEGACY_TOKEN_HEADER defaults to true
+// so existing clients keep working until it is switched off.
+func legacyTokenHeaderAllowed() bool {
+	allowed, err := strconv.ParseBool(os.Getenv("ALLOW_LEGACY_TOKEN_HEADER"))
+	return err != nil || allowed
+}
+
+// requestToken returns the token of the request and the scheme it was sent
+// with, either from the Authorization header or, if allowed, the legacy
+// token header. It aborts the request and returns ok false when there is no
+// usable token.
+func requestToken(c *gin.Context, allowLegacy bool) (token string, scheme string, ok bool) {
+	authorization := c.GetHeader("Authorization")
+	legacy := ""
+	if allowLegacy {
+		legacy = c.GetHeader("token")
+	}
+
+	if authorization == "" {
+		if legacy != "" {
+			return legacy, "Bearer", true
+		}
+		challenge(c, "Bearer", http.StatusUnauthorized, "", "no access token provided")
+		return "", "", false
+	}
+
+	scheme, token, _ = strings.Cut(authorization, " ")
+	token = strings.TrimSpace(token)
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		scheme = "Bearer"
+	case strings.EqualFold(scheme, "DPoP"):
+		scheme = "DPoP"
+	default:
+		challenge(c, "Bearer", http.StatusUnauthorized, "", "unsupported authorization scheme")
+		return "", "", false
+	}
+	if token == "" {
+		challenge(c, scheme, http.StatusBadRequest, errInvalidRequest, "the authorization header has no token")
+		return "", "", false
+	}
+	if legacy != "" {
+		challenge(c, scheme, http.StatusBadRequest, errInvalidRequest, "the token must be sent in one header only")
+		return "", "", false
+	}
+	return token, scheme, true
+}
+
+// validationFailure answers a token that ValidateToken rejected, telling an
+// expired token apart from one that is malformed or forged.
+func validationFailure(c *gin.Context, scheme string, msg string) {
+	if msg == "token is expired" {
+		challenge(c, scheme, http.StatusUnauthorized, errInvalidToken, "the token has expired")
+		return
+	}
+	challenge(c, scheme, http.StatusUnauthorized, errInvalidToken, "the token is malformed or its signature is invalid")
+}
+
+// challenge aborts the request with a WWW-Authenticate header as described
+// by RFC 6750. Without an error code only the realm is sent, which tells
+// the client to authenticate.
+func challenge(c *gin.Context, scheme string, status int, code string, description string, params ...string) {
+	header := fmt.Sprintf(`%s realm=%q`, scheme, helpers.Issuer())
+	if code != "" {
+		header += fmt.Sprintf(`, error=%q, error_description=%q`, code, description)
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		header += fmt.Sprintf(`, %s=%q`, params[i], params[i+1])
+	}
+	c.Header("WWW-Authenticate", header)
+
+	if code == "" {
+		c.AbortWithStatusJSON(status, gin.H{"error": description})
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// RequireScope only lets requests through whose access token was granted
+// scope. It must run after Authenticate.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helpers.HasScope(c.GetString("scope"), scope) {
+			InsufficientScope(c, scope)
+			return
+		}
+		c.Next()
+	}
+}
+
+// InsufficientScope answers a request whose token lacks scope with 403.
+func InsufficientScope(c *gin.Context, scope string) {
+	scheme := c.GetString("auth_scheme")
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	challenge(c, scheme, http.StatusForbidden, errInsufficientScope, "the token does not grant the "+scope+" scope", "scope", scope)
+}
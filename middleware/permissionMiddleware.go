@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"go-auth/helpers"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission only lets requests through whose access token grants
+// every one of permissions. It must run after Authenticate.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !helpers.HasPermission(c, permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error":              "forbidden",
+					"missing_permission": permission,
+				})
+				return
+			}
+		}
+		c.Next()
+	}
+}
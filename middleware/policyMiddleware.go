@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"go-auth/helpers"
+	"go-auth/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePolicy lets requests through when the access policies allow
+// action for the authenticated subject, either through the permission of
+// the same name or an allow policy, and no deny policy matches. It must
+// run after Authenticate. Checks on a specific resource are made by the
+// handlers, which know the resource.
+func RequirePolicy(policyservice services.PolicyService, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		decision := policyservice.Authorize(helpers.NewPolicyInput(c, action, nil))
+		if !decision.Allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":  "forbidden",
+				"reason": decision.Reason,
+			})
+			return
+		}
+		c.Next()
+	}
+}
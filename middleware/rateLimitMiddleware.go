@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-auth/models"
+	"go-auth/services"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxRateLimitBody bounds the body of requests limited by email address.
+const maxRateLimitBody = 1 << 16
+
+var errRateLimitBodyTooLarge = errors.New("request body too large")
+
+// RateLimit counts every request against each rule, keyed by the route and
+// the client IP or the email address in the JSON or form body, and answers
+// 429 once any of them is exhausted. The RateLimit headers describe the
+// rule closest to its limit. When the store fails, requests are let through.
+func RateLimit(ratelimitservice services.RateLimitService, rules ...models.RateLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var email string
+		for _, rule := range rules {
+			if rule.By != models.RateLimitByEmail {
+				continue
+			}
+			var err error
+			if email, err = requestEmail(c); err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, errRateLimitBodyTooLarge) {
+					status = http.StatusRequestEntityTooLarge
+				}
+				c.AbortWithStatusJSON(status, gin.H{"error": "invalid request body: " + err.Error()})
+				return
+			}
+			break
+		}
+
+		var tightest *models.RateLimitResult
+		var tightestRule models.RateLimitRule
+		var retryAfter time.Duration
+		for _, rule := range rules {
+			value := c.ClientIP()
+			if rule.By == models.RateLimitByEmail {
+				if email == "" {
+					continue
+				}
+				value = email
+			}
+
+			key := fmt.Sprintf("%s|%s|%s", c.FullPath(), rule.By, value)
+			result, err := ratelimitservice.Take(c.Request.Context(), key, rule)
+			if err != nil {
+				log.Println("error counting request for rate limit:", err)
+				continue
+			}
+			if !result.Allowed {
+				retryAfter = max(retryAfter, result.RetryAfter)
+			}
+			if tightest == nil || result.Remaining < tightest.Remaining {
+				tightest, tightestRule = &result, rule
+			}
+		}
+
+		if tightest != nil {
+			c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", tightestRule.Limit, int(tightestRule.Window.Seconds())))
+			c.Header("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			c.Header("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			c.Header("RateLimit-Reset", strconv.Itoa(seconds(tightest.Reset)))
+		}
+		if retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       "too many requests, please try again later",
+				"retry_after": seconds(retryAfter),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestEmail reads the email field of a JSON or form body and puts the
+// body back for the handler. A body too large or malformed to be read is
+// an error rather than a request without an email address, so that the
+// email rules cannot be dodged by padding or garbling it.
+func requestEmail(c *gin.Context) (string, error) {
+	if c.Request.Body == nil {
+		return "", nil
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRateLimitBody+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxRateLimitBody {
+		return "", errRateLimitBodyTooLarge
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", nil
+	}
+
+	var email string
+	if c.ContentType() == binding.MIMEPOSTForm {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", err
+		}
+		email = values.Get("email")
+	} else {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", err
+		}
+		email = req.Email
+	}
+	return strings.ToLower(strings.TrimSpace(email)), nil
+}
+
+// seconds rounds up so that a client waiting that long is not limited again.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -2,34 +2,46 @@ package middleware
 
 import (
 	"go-auth/helpers"
+	"go-auth/services"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func ResetTokenMiddleware() gin.HandlerFunc {
+// ResetTokenMiddleware accepts a password reset token the same ways
+// Authenticate accepts access tokens.
+func ResetTokenMiddleware(tokenservice services.TokenService) gin.HandlerFunc {
+	allowLegacy := legacyTokenHeaderAllowed()
 	return func(c *gin.Context) {
-		resetToken := c.Request.Header.Get("token")
-		if resetToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "no authorization header provided"})
-			c.Abort()
+		resetToken, scheme, ok := requestToken(c, allowLegacy)
+		if !ok {
 			return
 		}
 		claims, err := helpers.ValidateToken(resetToken)
-
 		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
+			validationFailure(c, scheme, err)
 			return
 		}
 
 		if claims.TokenType != "reset" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token type"})
+			challenge(c, scheme, http.StatusUnauthorized, errInvalidToken, "not a password reset token")
+			return
+		}
+
+		revoked, revokeErr := tokenservice.IsRevoked(c.Request.Context(), claims)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": revokeErr.Error()})
 			c.Abort()
 			return
 		}
+		if revoked {
+			challenge(c, scheme, http.StatusUnauthorized, errInvalidToken, "the token has already been used")
+			return
+		}
 
 		c.Set("email", claims.Email)
+		c.Set("jti", claims.ID)
+		c.Set("expires_at", claims.ExpiresAt.Time)
 		c.Next()
 	}
 }
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuthorizationCode struct {
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	CodeHash            string             `bson:"code_hash" json:"-"`
+	ClientID            string             `bson:"client_id" json:"client_id"`
+	UserID              string             `bson:"user_id" json:"user_id"`
+	RedirectURI         string             `bson:"redirect_uri" json:"redirect_uri"`
+	Scope               string             `bson:"scope" json:"scope"`
+	Nonce               string             `bson:"nonce,omitempty" json:"nonce,omitempty"`
+	CodeChallenge       string             `bson:"code_challenge" json:"-"`
+	CodeChallengeMethod string             `bson:"code_challenge_method" json:"code_challenge_method"`
+	AuthTime            time.Time          `bson:"auth_time" json:"auth_time"`
+	Used                bool               `bson:"used" json:"used"`
+	FamilyID            string             `bson:"family_id,omitempty" json:"-"` // revoked when the code is presented again
+	ExpiresAt           time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt           time.Time          `bson:"created_at" json:"created_at"`
+}
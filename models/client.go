@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Client struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ClientID      string             `bson:"client_id" json:"client_id"`
+	SecretHash    string             `bson:"secret_hash" json:"-"`
+	Name          string             `bson:"name" json:"name" validate:"required,max=64"`
+	RedirectURIs  []string           `bson:"redirect_uris" json:"redirect_uris" validate:"dive,url"`
+	Public        bool               `bson:"public" json:"public"`
+	GrantTypes    []string           `bson:"grant_types" json:"grant_types" validate:"dive,oneof=authorization_code refresh_token client_credentials urn:ietf:params:oauth:grant-type:device_code urn:ietf:params:oauth:grant-type:token-exchange"`
+	AllowedScopes []string           `bson:"allowed_scopes" json:"allowed_scopes"`
+	Owner         string             `bson:"owner" json:"owner"`
+	RequireDPoP   bool               `bson:"require_dpop" json:"require_dpop"`
+	Lifetimes     TokenLifetimes     `bson:"lifetimes" json:"lifetimes"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
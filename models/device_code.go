@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeUsed     = "used"
+)
+
+type DeviceCode struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	DeviceCodeHash string             `bson:"device_code_hash" json:"-"`
+	UserCode       string             `bson:"user_code" json:"user_code"`
+	ClientID       string             `bson:"client_id" json:"client_id"`
+	Scope          string             `bson:"scope" json:"scope"`
+	Status         string             `bson:"status" json:"status"`
+	UserID         string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Interval       int                `bson:"interval" json:"interval"`
+	LastPolledAt   time.Time          `bson:"last_polled_at,omitempty" json:"last_polled_at,omitempty"`
+	SlowDown       bool               `bson:"slow_down,omitempty" json:"-"` // the last poll came sooner than Interval
+	ApprovedAt     time.Time          `bson:"approved_at,omitempty" json:"approved_at,omitempty"`
+	ExpiresAt      time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// LoginAttempts tracks the failed logins of one account or client address.
+// Key is "user:<user_id>" or "ip:<address>".
+type LoginAttempts struct {
+	Key             string    `bson:"_id" json:"-"`
+	UserID          string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Failures        int       `bson:"failures" json:"failures"`
+	Lockouts        int       `bson:"lockouts" json:"lockouts"`
+	LockedUntil     time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	UnlockTokenHash string    `bson:"unlock_token_hash,omitempty" json:"-"`
+	UnlockExpiresAt time.Time `bson:"unlock_expires_at,omitempty" json:"-"`
+	LastFailureAt   time.Time `bson:"last_failure_at" json:"last_failure_at"`
+	// ExpiresAt forgets the attempts after a quiet period.
+	ExpiresAt time.Time `bson:"expires_at" json:"-"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+	// PolicyNotApplicable is the effect when no policy matched.
+	PolicyNotApplicable = "not_applicable"
+)
+
+// Policy is an attribute-based access rule. Its condition is an expression
+// over subject, resource and request; when it is true for one of the
+// actions, the effect applies. Deny policies override everything else.
+type Policy struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description,omitempty"`
+	// Actions are permissions such as users:read; "*" matches every action.
+	Actions   []string `bson:"actions" json:"actions"`
+	Effect    string   `bson:"effect" json:"effect"`
+	Condition string   `bson:"condition" json:"condition"`
+}
+
+// PolicyInput holds the attributes a decision is made on.
+type PolicyInput struct {
+	Action   string         `json:"action"`
+	Subject  map[string]any `json:"subject"`
+	Resource map[string]any `json:"resource,omitempty"`
+	Request  map[string]any `json:"request"`
+}
+
+// PolicyDecision is the outcome of an authorization check, with the result
+// of every policy that applies to the action.
+type PolicyDecision struct {
+	Action      string             `json:"action"`
+	Allowed     bool               `json:"allowed"`
+	Effect      string             `json:"effect"`
+	Reason      string             `json:"reason"`
+	Evaluations []PolicyEvaluation `json:"evaluations"`
+}
+
+type PolicyEvaluation struct {
+	Policy  string `json:"policy"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	// Error is set when the condition could not be evaluated; such a
+	// deny policy matches and such an allow policy does not.
+	Error string `json:"error,omitempty"`
+}
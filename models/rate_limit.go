@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	RateLimitSlidingWindow = "sliding_window"
+	RateLimitTokenBucket   = "token_bucket"
+
+	RateLimitByIP    = "ip"
+	RateLimitByEmail = "email"
+)
+
+// RateLimitRule allows Limit requests per Window for every value of By on
+// a route. A sliding window counts requests over the last Window; a token
+// bucket holds Limit tokens and refills them evenly over Window.
+type RateLimitRule struct {
+	By        string
+	Limit     int
+	Window    time.Duration
+	Algorithm string
+}
+
+// RateLimitResult is the state of one rule after a request was counted.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the current window ends or the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long a denied request has to wait.
+	RetryAfter time.Duration
+}
+
+// RateLimitCounter is the persisted state of a rule for one key. Sliding
+// windows use WindowStart, Count and Previous; token buckets use Tokens.
+type RateLimitCounter struct {
+	Key         string    `bson:"_id"`
+	WindowStart time.Time `bson:"window_start,omitempty"`
+	Count       int       `bson:"count,omitempty"`
+	Previous    int       `bson:"previous,omitempty"`
+	Tokens      float64   `bson:"tokens,omitempty"`
+	Allowed     bool      `bson:"allowed,omitempty"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReferenceToken holds the claims behind an opaque token. Only the hash of
+// the token is stored; Claims is the JSON encoded claim set.
+type ReferenceToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Claims    string             `bson:"claims" json:"-"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Device struct {
+	Name      string `bson:"name" json:"name"`
+	UserAgent string `bson:"user_agent" json:"user_agent"`
+	IP        string `bson:"ip" json:"ip"`
+}
+
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	FamilyID  string             `bson:"family_id" json:"family_id"`
+	ParentID  string             `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	ClientID  string             `bson:"client_id,omitempty" json:"client_id,omitempty"`
+	Scope     string             `bson:"scope,omitempty" json:"scope,omitempty"`
+	AuthTime  time.Time          `bson:"auth_time" json:"auth_time"`
+	JKT       string             `bson:"jkt,omitempty" json:"jkt,omitempty"`
+	Device    Device             `bson:"device" json:"device"`
+	Lifetimes TokenLifetimes     `bson:"lifetimes" json:"lifetimes"`
+	Used      bool               `bson:"used" json:"used"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	Revoked   bool               `bson:"revoked" json:"revoked"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevokedToken denies a single token by Jti, every token of a login session
+// by SessionID, or every token of UserID issued up to and including the
+// millisecond of IssuedBefore.
+type RevokedToken struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Jti          string             `bson:"jti,omitempty" json:"jti,omitempty"`
+	SessionID    string             `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	UserID       string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	IssuedBefore time.Time          `bson:"issued_before,omitempty" json:"issued_before,omitempty"`
+	Reason       string             `bson:"reason" json:"reason"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Role is a named set of permissions. System roles are seeded at startup
+// and cannot be deleted.
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string             `bson:"name" json:"name" validate:"required,max=32,alphanum,lowercase"`
+	Description string             `bson:"description" json:"description" validate:"max=256"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	System      bool               `bson:"system" json:"system"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is one login on one device. Its ID is the family id of the
+// refresh tokens issued for that login.
+type Session struct {
+	ID         string     `bson:"_id" json:"id"`
+	UserID     string     `bson:"user_id" json:"user_id"`
+	ClientID   string     `bson:"client_id,omitempty" json:"client_id,omitempty"`
+	Device     Device     `bson:"device" json:"device"`
+	Revoked    bool       `bson:"revoked" json:"-"`
+	RevokedAt  *time.Time `bson:"revoked_at,omitempty" json:"-"`
+	Current    bool       `bson:"-" json:"current"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time  `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time  `bson:"expires_at" json:"expires_at"`
+}
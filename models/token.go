@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+type LoginRequest struct {
+	Email    *string `json:"email" validate:"required,email"`
+	Password *string `json:"password" validate:"required"`
+	ClientID string  `json:"client_id"`
+	Scope    string  `json:"scope"`
+	Nonce    string  `json:"nonce"`
+	Device   Device  `json:"-"`
+	JKT      string  `json:"-"`
+	IP       string  `json:"-"`
+}
+
+// Grant describes what a token set is issued for. FamilyID and ParentID are
+// empty for a new login and set when a refresh token is rotated.
+type Grant struct {
+	ClientID string
+	Scope    string
+	Nonce    string
+	AuthTime time.Time
+	FamilyID string
+	ParentID string
+	Device   Device
+	JKT      string
+	// Lifetimes are the overrides of the client the grant is issued to.
+	Lifetimes TokenLifetimes
+}
+
+type Tokens struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	// RefreshExpiresIn is the lifetime of the refresh token in seconds.
+	RefreshExpiresIn int `json:"-"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// TokenLifetimes overrides the configured token lifetimes and session
+// timeouts, in seconds. A zero value keeps the configured setting.
+type TokenLifetimes struct {
+	AccessTokenTTL  int `bson:"access_token_ttl,omitempty" json:"access_token_ttl,omitempty" validate:"omitempty,min=60"`
+	RefreshTokenTTL int `bson:"refresh_token_ttl,omitempty" json:"refresh_token_ttl,omitempty" validate:"omitempty,min=60"`
+	IdleTimeout     int `bson:"idle_timeout,omitempty" json:"idle_timeout,omitempty" validate:"omitempty,min=60"`
+	AbsoluteTimeout int `bson:"absolute_timeout,omitempty" json:"absolute_timeout,omitempty" validate:"omitempty,min=60"`
+}
@@ -7,12 +7,17 @@ import (
 )
 
 type User struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Username   *string            `json:"username" bson:"username" validate:"required,max=24"`
-	Email      *string            `json:"email" bson:"email" validate:"email,required"`
-	Password   *string            `json:"password" validate:"required,min=6"`
-	User_type  *string            `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
-	Created_at time.Time          `json:"created_at" bson:"created_at"`
-	Updated_at time.Time          `json:"update_at" bson:"updated_at"`
-	User_id    string             `json:"user_id"`
+	ID             primitive.ObjectID `bson:"_id"`
+	Username       *string            `json:"username" bson:"username" validate:"required,max=24"`
+	Email          *string            `json:"email" bson:"email" validate:"email,required"`
+	Password       *string            `json:"password" validate:"required"`
+	User_type      *string            `json:"user_type" validate:"omitempty,eq=ADMIN|eq=USER"`
+	Created_at     time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at     time.Time          `json:"update_at" bson:"updated_at"`
+	User_id        string             `json:"user_id"`
+	Email_verified bool               `json:"email_verified" bson:"email_verified"`
+	Roles          []string           `json:"roles" bson:"roles"`
+	Attributes     map[string]string  `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	// PasswordChangeRequired is set when the password was found in a breach.
+	PasswordChangeRequired bool `json:"password_change_required" bson:"password_change_required"`
 }
@@ -3,15 +3,48 @@ package routes
 import (
 	"go-auth/controllers"
 	"go-auth/middleware"
+	"go-auth/models"
+	"go-auth/services"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthRoutes(incomingRoutes *gin.RouterGroup, uc *controllers.UserController) {
-	incomingRoutes.POST("/signup", uc.Signup)
-	incomingRoutes.POST("/login", uc.Login)
-	incomingRoutes.POST("/forgotpassword", uc.ForgotPassword)
-	incomingRoutes.POST("/verify_otp", uc.VerifyOTP)
-	incomingRoutes.POST("/password/reset", middleware.ResetTokenMiddleware(), uc.ResetPassword)
+// Rate limits of the unauthenticated auth endpoints. Per-email limits stop
+// guessing passwords and OTPs for one account and flooding one inbox;
+// per-IP limits stop a client from spreading that over many accounts.
+var (
+	signupLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 10, Window: time.Hour},
+	}
+	loginLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 20, Window: time.Minute, Algorithm: models.RateLimitTokenBucket},
+		{By: models.RateLimitByEmail, Limit: 10, Window: 15 * time.Minute},
+	}
+	forgotPasswordLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 10, Window: time.Hour},
+		{By: models.RateLimitByEmail, Limit: 3, Window: time.Hour},
+	}
+	verifyOTPLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 20, Window: 15 * time.Minute},
+		{By: models.RateLimitByEmail, Limit: 5, Window: 15 * time.Minute},
+	}
+	resetPasswordLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 10, Window: time.Hour},
+		{By: models.RateLimitByEmail, Limit: 5, Window: time.Hour},
+	}
+	unlockLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 10, Window: time.Hour},
+	}
+)
+
+func AuthRoutes(incomingRoutes *gin.RouterGroup, uc *controllers.UserController, ts services.TokenService, rl services.RateLimitService) {
+	incomingRoutes.POST("/signup", middleware.RateLimit(rl, signupLimits...), uc.Signup)
+	incomingRoutes.POST("/login", middleware.RateLimit(rl, loginLimits...), uc.Login)
+	incomingRoutes.POST("/forgotpassword", middleware.RateLimit(rl, forgotPasswordLimits...), uc.ForgotPassword)
+	incomingRoutes.POST("/verify_otp", middleware.RateLimit(rl, verifyOTPLimits...), uc.VerifyOTP)
+	incomingRoutes.POST("/password/reset", middleware.RateLimit(rl, resetPasswordLimits...), middleware.ResetTokenMiddleware(ts), uc.ResetPassword)
+	incomingRoutes.GET("/password/policy", uc.GetPasswordPolicy)
 	incomingRoutes.POST("/refresh", uc.Refresh)
+	incomingRoutes.GET("/account/unlock", middleware.RateLimit(rl, unlockLimits...), uc.UnlockAccount)
 }
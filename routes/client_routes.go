@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"go-auth/controllers"
+	"go-auth/helpers"
+	"go-auth/middleware"
+	"go-auth/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ClientRoutes(incomingRoutes *gin.RouterGroup, cc *controllers.ClientController, ts services.TokenService) {
+	clientRoutes := incomingRoutes.Group("/clients")
+	clientRoutes.Use(middleware.Authenticate(ts))
+	clientRoutes.POST("/create", middleware.RequirePermission(helpers.PermClientsWrite), cc.CreateClient)
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"go-auth/controllers"
+	"go-auth/middleware"
+	"go-auth/models"
+	"go-auth/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate limits of the OAuth endpoints. The authorize and device forms check
+// passwords like /login does and share its limits; the token, introspection
+// and revocation endpoints check client secrets, codes and tokens, which are
+// only limited per IP.
+var (
+	tokenLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 60, Window: time.Minute, Algorithm: models.RateLimitTokenBucket},
+	}
+	deviceCodeLimits = []models.RateLimitRule{
+		{By: models.RateLimitByIP, Limit: 10, Window: time.Minute},
+	}
+)
+
+func OAuthRoutes(incomingRoutes *gin.RouterGroup, oc *controllers.OAuthController, rl services.RateLimitService) {
+	oauthRoutes := incomingRoutes.Group("/oauth")
+	oauthRoutes.GET("/authorize", oc.Authorize)
+	oauthRoutes.POST("/authorize", middleware.RateLimit(rl, loginLimits...), oc.Authorize)
+	oauthRoutes.POST("/token", middleware.RateLimit(rl, tokenLimits...), oc.Token)
+	oauthRoutes.POST("/device/code", middleware.RateLimit(rl, deviceCodeLimits...), oc.DeviceAuthorization)
+	oauthRoutes.GET("/device", oc.Device)
+	oauthRoutes.POST("/device", middleware.RateLimit(rl, loginLimits...), oc.Device)
+	oauthRoutes.POST("/introspect", middleware.RateLimit(rl, tokenLimits...), oc.Introspect)
+	oauthRoutes.POST("/revoke", middleware.RateLimit(rl, tokenLimits...), oc.Revoke)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"go-auth/controllers"
+	"go-auth/helpers"
+	"go-auth/middleware"
+	"go-auth/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PolicyRoutes(incomingRoutes *gin.RouterGroup, pc *controllers.PolicyController, ts services.TokenService) {
+	policyRoutes := incomingRoutes.Group("/policies")
+	policyRoutes.Use(middleware.Authenticate(ts), middleware.RequirePermission(helpers.PermPoliciesRead))
+	policyRoutes.GET("", pc.GetPolicies)
+	policyRoutes.POST("/explain", pc.Explain)
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"go-auth/controllers"
+	"go-auth/helpers"
+	"go-auth/middleware"
+	"go-auth/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RoleRoutes(incomingRoutes *gin.RouterGroup, rc *controllers.RoleController, ts services.TokenService) {
+	roleRoutes := incomingRoutes.Group("/roles")
+	roleRoutes.Use(middleware.Authenticate(ts))
+	roleRoutes.GET("", middleware.RequirePermission(helpers.PermRolesRead), rc.GetRoles)
+	roleRoutes.GET("/permissions", middleware.RequirePermission(helpers.PermRolesRead), rc.GetPermissions)
+	roleRoutes.POST("", middleware.RequirePermission(helpers.PermRolesWrite), rc.CreateRole)
+	roleRoutes.PATCH("/:name", middleware.RequirePermission(helpers.PermRolesWrite), rc.UpdateRole)
+	roleRoutes.DELETE("/:name", middleware.RequirePermission(helpers.PermRolesWrite), rc.DeleteRole)
+	roleRoutes.PUT("/assign/:user_id", middleware.RequirePermission(helpers.PermRolesWrite), rc.AssignRoles)
+}
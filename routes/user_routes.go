@@ -2,17 +2,38 @@ package routes
 
 import (
 	"go-auth/controllers"
+	"go-auth/helpers"
 	"go-auth/middleware"
+	"go-auth/models"
+	"go-auth/services"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(incomingRoutes *gin.RouterGroup, uc *controllers.UserController) {
+// changePasswordLimits complement the lockout, which counts wrong current
+// passwords per account, with a limit per IP.
+var changePasswordLimits = []models.RateLimitRule{
+	{By: models.RateLimitByIP, Limit: 10, Window: 15 * time.Minute},
+}
+
+func UserRoutes(incomingRoutes *gin.RouterGroup, uc *controllers.UserController, ts services.TokenService, ps services.PolicyService, rl services.RateLimitService) {
+	incomingRoutes.GET("/userinfo", middleware.Authenticate(ts), middleware.RequireScope("openid"), uc.Userinfo)
+	incomingRoutes.POST("/userinfo", middleware.Authenticate(ts), middleware.RequireScope("openid"), uc.Userinfo)
+
 	userRoutes := incomingRoutes.Group("/user")
-	userRoutes.Use(middleware.Authenticate())
+	userRoutes.Use(middleware.Authenticate(ts))
 	userRoutes.GET("/getuser/:user_id", uc.GetUser)
-	userRoutes.GET("/getall", uc.GetAll)
+	userRoutes.GET("/getall", middleware.RequirePolicy(ps, helpers.PermUsersRead), uc.GetAll)
 	userRoutes.PATCH("/update_user", uc.UpdateUser)
+	userRoutes.POST("/password", middleware.RateLimit(rl, changePasswordLimits...), uc.ChangePassword)
 	userRoutes.POST("/delete/:user_id", uc.DeleteUser)
-	userRoutes.POST("/logout", controllers.Logout)
+	userRoutes.PUT("/attributes/:user_id", middleware.RequirePolicy(ps, helpers.PermUsersWrite), uc.SetAttributes)
+	userRoutes.POST("/unlock/:user_id", middleware.RequirePolicy(ps, helpers.PermUsersWrite), uc.AdminUnlock)
+	userRoutes.POST("/logout", uc.Logout)
+	userRoutes.POST("/revoke", middleware.RequirePermission(helpers.PermTokensRevoke), uc.RevokeToken)
+	userRoutes.GET("/sessions", uc.ListSessions)
+	userRoutes.DELETE("/sessions", uc.RevokeOtherSessions)
+	userRoutes.DELETE("/sessions/:id", uc.RevokeSession)
+	userRoutes.POST("/device/approve", uc.ApproveDevice)
 }
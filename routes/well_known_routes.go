@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"go-auth/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func WellKnownRoutes(incomingRoutes *gin.RouterGroup) {
+	wellKnown := incomingRoutes.Group("/.well-known")
+	wellKnown.GET("/jwks.json", controllers.JWKS)
+	wellKnown.GET("/openid-configuration", controllers.OpenIDConfiguration)
+}
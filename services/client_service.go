@@ -0,0 +1,12 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+)
+
+type ClientService interface {
+	CreateClient(context.Context, *models.Client) (string, error)
+	GetClient(context.Context, string) (*models.Client, error)
+	AuthenticateClient(context.Context, string, string) (*models.Client, error)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"go-auth/helpers"
+	"go-auth/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidClient = errors.New("invalid client credentials")
+
+type ClientServiceImpl struct {
+	clientcollection *mongo.Collection
+}
+
+func NewClientService(clientcollection *mongo.Collection) ClientService {
+	return &ClientServiceImpl{
+		clientcollection: clientcollection,
+	}
+}
+
+// CreateClient registers a client and returns its secret. Only a hash of the
+// secret is stored, so it cannot be shown again. Public clients get no secret.
+func (s *ClientServiceImpl) CreateClient(c context.Context, client *models.Client) (string, error) {
+	secret := ""
+	client.SecretHash = ""
+	if !client.Public {
+		secret = helpers.NewTokenID() + helpers.NewTokenID()
+		client.SecretHash = helpers.HashToken(secret)
+	}
+
+	client.ID = primitive.NewObjectID()
+	client.ClientID = helpers.NewTokenID()
+	client.CreatedAt = time.Now()
+
+	if _, err := s.clientcollection.InsertOne(c, client); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *ClientServiceImpl) GetClient(c context.Context, clientId string) (*models.Client, error) {
+	var client models.Client
+	err := s.clientcollection.FindOne(c, bson.M{"client_id": clientId}).Decode(&client)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New("client not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *ClientServiceImpl) AuthenticateClient(c context.Context, clientId string, secret string) (*models.Client, error) {
+	if clientId == "" || secret == "" {
+		return nil, ErrInvalidClient
+	}
+	client, err := s.GetClient(c, clientId)
+	if err != nil || client.Public {
+		return nil, ErrInvalidClient
+	}
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(helpers.HashToken(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-auth/helpers"
+	"go-auth/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+	ErrInvalidUserCode      = errors.New("user code is invalid or expired")
+)
+
+// userCodeAttempts bounds the retries when a new user code collides with
+// one that is still stored.
+const userCodeAttempts = 5
+
+// SaveDeviceCode stores a device code under a new user code, which is set on
+// record. User codes are unique, so a code can never name two grants.
+func (t *TokenServiceImpl) SaveDeviceCode(c context.Context, record *models.DeviceCode, deviceCode string) error {
+	record.DeviceCodeHash = helpers.HashToken(deviceCode)
+	record.Status = models.DeviceCodePending
+	record.CreatedAt = time.Now()
+
+	var err error
+	for range userCodeAttempts {
+		record.UserCode = helpers.NewUserCode()
+		if _, err = t.devicecollection.InsertOne(c, record); !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (t *TokenServiceImpl) GetDeviceCodeByUserCode(c context.Context, userCode string) (*models.DeviceCode, error) {
+	var record models.DeviceCode
+	err := t.devicecollection.FindOne(c, bson.M{
+		"user_code":  userCode,
+		"status":     models.DeviceCodePending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// DecideDeviceCode records the user's answer for a pending user code.
+func (t *TokenServiceImpl) DecideDeviceCode(c context.Context, userCode string, userId string, approve bool) error {
+	update := bson.M{"status": models.DeviceCodeDenied}
+	if approve {
+		update = bson.M{"status": models.DeviceCodeApproved, "user_id": userId, "approved_at": time.Now()}
+	}
+
+	result, err := t.devicecollection.UpdateOne(c,
+		bson.M{"user_code": userCode, "status": models.DeviceCodePending, "expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": update},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount != 1 {
+		return ErrInvalidUserCode
+	}
+	return nil
+}
+
+// PollDeviceCode answers a device polling the token endpoint. It returns
+// the record once, after the user approved it, and the RFC 8628 errors
+// otherwise. Polling faster than the interval increases it by 5 seconds.
+func (t *TokenServiceImpl) PollDeviceCode(c context.Context, deviceCode string) (*models.DeviceCode, error) {
+	now := time.Now().Truncate(time.Millisecond)
+
+	// the poll is recorded and checked against the previous one in a single
+	// update, so that concurrent polls cannot both pass as slow enough
+	sincePoll := bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$last_polled_at", time.Unix(0, 0)}}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"slow_down": bson.M{"$lt": bson.A{sincePoll, bson.M{"$multiply": bson.A{"$interval", 1000}}}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"interval":       bson.M{"$cond": bson.A{"$slow_down", bson.M{"$add": bson.A{"$interval", 5}}, "$interval"}},
+			"last_polled_at": now,
+		}}},
+	}
+
+	var record models.DeviceCode
+	err := t.devicecollection.FindOneAndUpdate(c,
+		bson.M{"device_code_hash": helpers.HashToken(deviceCode)},
+		pipeline,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now.After(record.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+	if record.SlowDown {
+		return nil, ErrSlowDown
+	}
+
+	switch record.Status {
+	case models.DeviceCodePending:
+		return nil, ErrAuthorizationPending
+	case models.DeviceCodeDenied:
+		return nil, ErrAccessDenied
+	case models.DeviceCodeApproved:
+		err := t.devicecollection.FindOneAndUpdate(c,
+			bson.M{"_id": record.ID, "status": models.DeviceCodeApproved},
+			bson.M{"$set": bson.M{"status": models.DeviceCodeUsed}},
+		).Decode(&record)
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidGrant
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}
+	return nil, ErrInvalidGrant
+}
@@ -0,0 +1,13 @@
+package services
+
+import (
+	"context"
+)
+
+type LockoutService interface {
+	Check(context.Context, string, string) error
+	RecordFailure(context.Context, string, string) error
+	RecordSuccess(context.Context, string) error
+	Unlock(context.Context, string) error
+	UnlockWithToken(context.Context, string) error
+}
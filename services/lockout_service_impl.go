@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-auth/helpers"
+	"go-auth/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAccountLocked       = errors.New("account is temporarily locked after too many failed logins")
+	ErrTooManyFailedLogins = errors.New("too many failed logins from this address, please try again later")
+	ErrInvalidUnlockToken  = errors.New("unlock link is invalid or expired")
+	ErrAccountNotLocked    = errors.New("account is not locked")
+)
+
+// LockoutError is returned while an account or address is locked. It wraps
+// ErrAccountLocked or ErrTooManyFailedLogins.
+type LockoutError struct {
+	Err   error
+	Until time.Time
+	// UnlockToken is set when this failure locked the account, so that the
+	// caller can send the unlock link.
+	UnlockToken string
+}
+
+func (e *LockoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LockoutError) Unwrap() error {
+	return e.Err
+}
+
+// LockoutServiceImpl keeps failed login counts of accounts and addresses in
+// MongoDB so that locks survive restarts and hold across replicas.
+type LockoutServiceImpl struct {
+	lockoutcollection *mongo.Collection
+}
+
+func NewLockoutService(lockoutcollection *mongo.Collection) LockoutService {
+	return &LockoutServiceImpl{
+		lockoutcollection: lockoutcollection,
+	}
+}
+
+func userKey(userId string) string {
+	return "user:" + userId
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a LockoutError when the account or the address is locked.
+// userId may be empty when the email matched no account.
+func (l *LockoutServiceImpl) Check(c context.Context, userId string, ip string) error {
+	keys := []string{ipKey(ip)}
+	if userId != "" {
+		keys = append(keys, userKey(userId))
+	}
+	cursor, err := l.lockoutcollection.Find(c, bson.M{
+		"_id":          bson.M{"$in": keys},
+		"locked_until": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	var locked []models.LoginAttempts
+	if err := cursor.All(c, &locked); err != nil {
+		return err
+	}
+	for _, attempts := range locked {
+		if attempts.UserID != "" {
+			return &LockoutError{Err: ErrAccountLocked, Until: attempts.LockedUntil}
+		}
+	}
+	if len(locked) > 0 {
+		return &LockoutError{Err: ErrTooManyFailedLogins, Until: locked[0].LockedUntil}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login. When it reaches a threshold the
+// account or address is locked and a LockoutError is returned.
+func (l *LockoutServiceImpl) RecordFailure(c context.Context, userId string, ip string) error {
+	config := helpers.Lockout()
+	var lockErr error
+	if userId != "" {
+		until, locked, err := l.recordFailure(c, userKey(userId), userId, config.Threshold)
+		if err != nil {
+			return err
+		}
+		if locked {
+			token := helpers.NewTokenID()
+			_, err := l.lockoutcollection.UpdateOne(c,
+				bson.M{"_id": userKey(userId)},
+				bson.M{"$set": bson.M{
+					"unlock_token_hash": helpers.HashToken(token),
+					"unlock_expires_at": time.Now().Add(helpers.UnlockTokenTTL),
+				}},
+			)
+			if err != nil {
+				return err
+			}
+			lockErr = &LockoutError{Err: ErrAccountLocked, Until: until, UnlockToken: token}
+		}
+	}
+
+	until, locked, err := l.recordFailure(c, ipKey(ip), "", config.IPThreshold)
+	if err != nil {
+		return err
+	}
+	if lockErr == nil && locked {
+		lockErr = &LockoutError{Err: ErrTooManyFailedLogins, Until: until}
+	}
+	return lockErr
+}
+
+// recordFailure counts a failure for key and locks it once threshold is
+// reached. Only one of concurrent failures can take the lock, since the
+// lock resets the count it is conditioned on.
+func (l *LockoutServiceImpl) recordFailure(c context.Context, key string, userId string, threshold int) (time.Time, bool, error) {
+	config := helpers.Lockout()
+	now := time.Now()
+
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"last_failure_at": now, "expires_at": now.Add(config.ResetAfter)},
+	}
+	if userId != "" {
+		update["$setOnInsert"] = bson.M{"user_id": userId}
+	}
+	var attempts models.LoginAttempts
+	err := l.lockoutcollection.FindOneAndUpdate(c, bson.M{"_id": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if attempts.Failures < threshold {
+		return time.Time{}, false, nil
+	}
+
+	until := now.Add(helpers.LockoutDuration(attempts.Lockouts + 1))
+	result, err := l.lockoutcollection.UpdateOne(c,
+		bson.M{"_id": key, "failures": bson.M{"$gte": threshold}},
+		bson.M{
+			"$inc": bson.M{"lockouts": 1},
+			"$set": bson.M{
+				"failures":     0,
+				"locked_until": until,
+				"expires_at":   until.Add(config.ResetAfter),
+			},
+		},
+	)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, result.ModifiedCount == 1, nil
+}
+
+// RecordSuccess forgets the failures of an account after it logged in.
+// Failures of the address are kept, so that an attacker cannot reset them
+// by logging into an own account.
+func (l *LockoutServiceImpl) RecordSuccess(c context.Context, userId string) error {
+	_, err := l.lockoutcollection.DeleteOne(c, bson.M{"_id": userKey(userId)})
+	return err
+}
+
+// Unlock lifts the lock of an account and forgets its failures.
+func (l *LockoutServiceImpl) Unlock(c context.Context, userId string) error {
+	result, err := l.lockoutcollection.DeleteOne(c, bson.M{"_id": userKey(userId), "locked_until": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAccountNotLocked
+	}
+	return nil
+}
+
+// UnlockWithToken lifts the lock of the account an unlock email was sent
+// for. The link can only be used once.
+func (l *LockoutServiceImpl) UnlockWithToken(c context.Context, token string) error {
+	result, err := l.lockoutcollection.DeleteOne(c, bson.M{
+		"unlock_token_hash": helpers.HashToken(token),
+		"unlock_expires_at": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrInvalidUnlockToken
+	}
+	return nil
+}
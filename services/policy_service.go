@@ -0,0 +1,13 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+)
+
+type PolicyService interface {
+	LoadPolicies(context.Context) error
+	WatchPolicies(context.Context)
+	GetPolicies() []models.Policy
+	Authorize(models.PolicyInput) models.PolicyDecision
+}
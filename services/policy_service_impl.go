@@ -0,0 +1,235 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-auth/helpers"
+	"go-auth/models"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type compiledPolicy struct {
+	policy    models.Policy
+	condition *helpers.Expression
+}
+
+// PolicyServiceImpl evaluates attribute-based policies loaded from a JSON
+// file or, when no file is configured, from MongoDB. Policies are compiled
+// on load; a set with an invalid policy is rejected as a whole and the
+// previous set stays in force.
+type PolicyServiceImpl struct {
+	policycollection *mongo.Collection
+	file             string
+	interval         time.Duration
+
+	mu       sync.RWMutex
+	policies []compiledPolicy
+	// loaded is the raw policy set last applied, to skip unchanged reloads
+	loaded []byte
+}
+
+func NewPolicyService(policycollection *mongo.Collection, file string, interval time.Duration) PolicyService {
+	return &PolicyServiceImpl{
+		policycollection: policycollection,
+		file:             file,
+		interval:         interval,
+	}
+}
+
+// LoadPolicies reads and compiles the policies, replacing the active set
+// when they changed.
+func (p *PolicyServiceImpl) LoadPolicies(c context.Context) error {
+	policies, err := p.readPolicies(c)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(policies)
+	if err != nil {
+		return err
+	}
+
+	p.mu.RLock()
+	unchanged := p.loaded != nil && bytes.Equal(raw, p.loaded)
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	compiled := make([]compiledPolicy, 0, len(policies))
+	names := map[string]bool{}
+	for _, policy := range policies {
+		if err := validatePolicy(policy); err != nil {
+			return err
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("policy %q is defined twice", policy.Name)
+		}
+		names[policy.Name] = true
+		condition, err := helpers.CompilePolicyCondition(policy.Condition)
+		if err != nil {
+			return fmt.Errorf("policy %q: %w", policy.Name, err)
+		}
+		compiled = append(compiled, compiledPolicy{policy: policy, condition: condition})
+	}
+
+	p.mu.Lock()
+	p.policies = compiled
+	p.loaded = raw
+	p.mu.Unlock()
+	log.Printf("loaded %d access policies", len(compiled))
+	return nil
+}
+
+func (p *PolicyServiceImpl) readPolicies(c context.Context) ([]models.Policy, error) {
+	policies := []models.Policy{}
+	if p.file != "" {
+		data, err := os.ReadFile(p.file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &policies); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.file, err)
+		}
+		return policies, nil
+	}
+
+	cursor, err := p.policycollection.Find(c, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func validatePolicy(policy models.Policy) error {
+	if policy.Name == "" {
+		return errors.New("policy without a name")
+	}
+	if policy.Effect != models.PolicyAllow && policy.Effect != models.PolicyDeny {
+		return fmt.Errorf("policy %q: effect must be allow or deny", policy.Name)
+	}
+	if len(policy.Actions) == 0 {
+		return fmt.Errorf("policy %q: no actions", policy.Name)
+	}
+	for _, action := range policy.Actions {
+		if action != "*" && !helpers.IsKnownPermission(action) {
+			return fmt.Errorf("policy %q: unknown action %q", policy.Name, action)
+		}
+	}
+	return nil
+}
+
+// WatchPolicies reloads the policies until ctx is done. Errors are logged
+// and leave the active policies in place.
+func (p *PolicyServiceImpl) WatchPolicies(ctx context.Context) {
+	if p.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.LoadPolicies(ctx); err != nil {
+				log.Println("error reloading access policies:", err)
+			}
+		}
+	}
+}
+
+func (p *PolicyServiceImpl) GetPolicies() []models.Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	policies := make([]models.Policy, 0, len(p.policies))
+	for _, compiled := range p.policies {
+		policies = append(policies, compiled.policy)
+	}
+	return policies
+}
+
+// Authorize decides on input. A matching deny policy always wins;
+// otherwise the action is allowed when the subject owns the resource or
+// holds the permission of the same name, or when an allow policy matches.
+// A condition that cannot be evaluated fails closed: the deny policy
+// applies and the allow policy does not. Every applicable policy is
+// evaluated so that the decision can be explained.
+func (p *PolicyServiceImpl) Authorize(input models.PolicyInput) models.PolicyDecision {
+	vars := map[string]any{
+		"subject":  input.Subject,
+		"resource": input.Resource,
+		"request":  input.Request,
+	}
+	if input.Resource == nil {
+		vars["resource"] = map[string]any{}
+	}
+
+	decision := models.PolicyDecision{
+		Action:      input.Action,
+		Effect:      models.PolicyNotApplicable,
+		Evaluations: []models.PolicyEvaluation{},
+	}
+	var allowedBy, deniedBy string
+
+	p.mu.RLock()
+	for _, compiled := range p.policies {
+		policy := compiled.policy
+		if !slices.Contains(policy.Actions, input.Action) && !slices.Contains(policy.Actions, "*") {
+			continue
+		}
+		evaluation := models.PolicyEvaluation{Policy: policy.Name, Effect: policy.Effect}
+		matched, err := compiled.condition.EvalBool(vars)
+		if err != nil {
+			evaluation.Error = err.Error()
+			matched = policy.Effect == models.PolicyDeny
+		}
+		evaluation.Matched = matched
+		decision.Evaluations = append(decision.Evaluations, evaluation)
+
+		if !matched {
+			continue
+		}
+		if policy.Effect == models.PolicyDeny && deniedBy == "" {
+			deniedBy = policy.Name
+		}
+		if policy.Effect == models.PolicyAllow && allowedBy == "" {
+			allowedBy = policy.Name
+		}
+	}
+	p.mu.RUnlock()
+
+	grantedBy := helpers.PolicyGrant(input)
+	switch {
+	case deniedBy != "":
+		decision.Effect = models.PolicyDeny
+		decision.Reason = fmt.Sprintf("denied by policy %q", deniedBy)
+	case grantedBy != "":
+		if allowedBy != "" {
+			decision.Effect = models.PolicyAllow
+		}
+		decision.Allowed = true
+		decision.Reason = "granted by " + grantedBy
+	case allowedBy != "":
+		decision.Effect = models.PolicyAllow
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf("allowed by policy %q", allowedBy)
+	default:
+		decision.Reason = "no permission or policy grants " + input.Action
+	}
+	return decision
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"go-auth/helpers"
+	"go-auth/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPolicyService(t *testing.T, policies ...models.Policy) PolicyService {
+	t.Helper()
+	data, err := json.Marshal(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "policies.json")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	service := NewPolicyService(nil, file, 0)
+	if err := service.LoadPolicies(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func policyInput(permissions []string, resource map[string]any) models.PolicyInput {
+	return models.PolicyInput{
+		Action:   helpers.PermUsersRead,
+		Subject:  map[string]any{"user_id": "admin", "permissions": permissions},
+		Resource: resource,
+		Request:  map[string]any{"ip": "192.0.2.1"},
+	}
+}
+
+func TestAuthorizeDenyFailsClosed(t *testing.T) {
+	service := newTestPolicyService(t, models.Policy{
+		Name:      "same-department",
+		Actions:   []string{helpers.PermUsersRead},
+		Effect:    models.PolicyDeny,
+		Condition: `resource.department != subject.department`,
+	})
+
+	tests := []struct {
+		name     string
+		resource map[string]any
+	}{
+		{name: "missing field", resource: map[string]any{"user_id": "user-1"}},
+		{name: "no resource", resource: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := service.Authorize(policyInput([]string{helpers.PermUsersRead}, tt.resource))
+			if decision.Allowed || decision.Effect != models.PolicyDeny {
+				t.Fatalf("decision = %+v, want denied", decision)
+			}
+			if len(decision.Evaluations) != 1 || decision.Evaluations[0].Error == "" || !decision.Evaluations[0].Matched {
+				t.Errorf("evaluations = %+v, want one matched with an error", decision.Evaluations)
+			}
+		})
+	}
+}
+
+func TestAuthorizeAllowFailsClosed(t *testing.T) {
+	service := newTestPolicyService(t, models.Policy{
+		Name:      "support-reads-customers",
+		Actions:   []string{helpers.PermUsersRead},
+		Effect:    models.PolicyAllow,
+		Condition: `resource.tier == "customer" || subject.team == "support"`,
+	})
+
+	decision := service.Authorize(policyInput(nil, map[string]any{"user_id": "user-1"}))
+	if decision.Allowed || decision.Effect != models.PolicyNotApplicable {
+		t.Fatalf("decision = %+v, want not allowed", decision)
+	}
+	if len(decision.Evaluations) != 1 || decision.Evaluations[0].Error == "" || decision.Evaluations[0].Matched {
+		t.Errorf("evaluations = %+v, want one unmatched with an error", decision.Evaluations)
+	}
+
+	// the permission still grants the action on its own
+	decision = service.Authorize(policyInput([]string{helpers.PermUsersRead}, map[string]any{"user_id": "user-1"}))
+	if !decision.Allowed {
+		t.Errorf("decision = %+v, want allowed by permission", decision)
+	}
+}
+
+func TestAuthorizeDenyNotMatching(t *testing.T) {
+	service := newTestPolicyService(t, models.Policy{
+		Name:      "same-department",
+		Actions:   []string{"*"},
+		Effect:    models.PolicyDeny,
+		Condition: `has(resource.department) && resource.department != "finance"`,
+	})
+
+	decision := service.Authorize(policyInput([]string{helpers.PermUsersRead}, map[string]any{"user_id": "user-1"}))
+	if !decision.Allowed {
+		t.Errorf("decision = %+v, want allowed", decision)
+	}
+	decision = service.Authorize(policyInput([]string{helpers.PermUsersRead}, map[string]any{"department": "sales"}))
+	if decision.Allowed || decision.Reason != `denied by policy "same-department"` {
+		t.Errorf("decision = %+v, want denied", decision)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often expired counters are dropped from
+// memory.
+const rateLimitSweepInterval = time.Minute
+
+// MemoryRateLimitService keeps counters in process. It is only accurate
+// when a single instance serves the routes it limits.
+type MemoryRateLimitService struct {
+	mu        sync.Mutex
+	counters  map[string]*models.RateLimitCounter
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitService() RateLimitService {
+	return &MemoryRateLimitService{
+		counters:  map[string]*models.RateLimitCounter{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryRateLimitService) Take(c context.Context, key string, rule models.RateLimitRule) (models.RateLimitResult, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	counter, ok := m.counters[key]
+	if !ok {
+		counter = &models.RateLimitCounter{Key: key, Tokens: float64(rule.Limit), UpdatedAt: now}
+		m.counters[key] = counter
+	}
+
+	if rule.Algorithm == models.RateLimitTokenBucket {
+		counter.Tokens = refill(rule, counter.Tokens, counter.UpdatedAt, now)
+		counter.Allowed = counter.Tokens >= 1
+		if counter.Allowed {
+			counter.Tokens--
+		}
+		counter.UpdatedAt = now
+		counter.ExpiresAt = now.Add(rule.Window)
+		return tokenBucket(rule, counter.Tokens, counter.Allowed), nil
+	}
+
+	windowStart := now.Truncate(rule.Window)
+	switch {
+	case counter.WindowStart.Equal(windowStart):
+		counter.Count++
+	case counter.WindowStart.Equal(windowStart.Add(-rule.Window)):
+		counter.Previous, counter.Count = counter.Count, 1
+	default:
+		counter.Previous, counter.Count = 0, 1
+	}
+	counter.WindowStart = windowStart
+	counter.UpdatedAt = now
+	counter.ExpiresAt = windowStart.Add(2 * rule.Window)
+	return slidingWindow(rule, now, windowStart, counter.Count, counter.Previous), nil
+}
+
+// sweep drops expired counters. The caller holds mu.
+func (m *MemoryRateLimitService) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	for key, counter := range m.counters {
+		if now.After(counter.ExpiresAt) {
+			delete(m.counters, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+	"math"
+	"time"
+)
+
+type RateLimitService interface {
+	// Take counts a request for key under rule.
+	Take(context.Context, string, models.RateLimitRule) (models.RateLimitResult, error)
+}
+
+// slidingWindow approximates the requests over the last window from the
+// counts of the current and the previous fixed window, weighting the
+// previous one by how much of it still overlaps. count already includes
+// the request being decided.
+func slidingWindow(rule models.RateLimitRule, now time.Time, windowStart time.Time, count int, previous int) models.RateLimitResult {
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(rule.Window)
+	estimate := float64(previous)*weight + float64(count)
+
+	result := models.RateLimitResult{
+		Allowed:   estimate <= float64(rule.Limit),
+		Limit:     rule.Limit,
+		Remaining: max(0, rule.Limit-int(math.Ceil(estimate))),
+		Reset:     rule.Window - elapsed,
+	}
+	if result.Allowed {
+		return result
+	}
+
+	// wait until the estimate, with the next request, fits the limit again
+	limit := float64(rule.Limit - 1)
+	if float64(count) <= limit && previous > 0 {
+		fraction := 1 - (limit-float64(count))/float64(previous)
+		result.RetryAfter = time.Duration(fraction*float64(rule.Window)) - elapsed
+	} else {
+		fraction := 1 - limit/float64(count)
+		result.RetryAfter = rule.Window - elapsed + time.Duration(fraction*float64(rule.Window))
+	}
+	result.Reset = max(result.Reset, result.RetryAfter)
+	return result
+}
+
+// tokenBucket reports on a bucket that had tokens left before the request
+// was decided; allowed is whether a token was taken.
+func tokenBucket(rule models.RateLimitRule, tokens float64, allowed bool) models.RateLimitResult {
+	perToken := rule.Window / time.Duration(rule.Limit)
+	result := models.RateLimitResult{
+		Allowed:   allowed,
+		Limit:     rule.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(rule.Limit) - tokens) * float64(perToken)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	return result
+}
+
+// refill returns the tokens of a bucket last updated at updatedAt.
+func refill(rule models.RateLimitRule, tokens float64, updatedAt time.Time, now time.Time) float64 {
+	rate := float64(rule.Limit) / rule.Window.Seconds()
+	return math.Min(float64(rule.Limit), tokens+now.Sub(updatedAt).Seconds()*rate)
+}
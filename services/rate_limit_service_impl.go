@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimitServiceImpl keeps counters in MongoDB so that every replica
+// shares them. Each request is counted with a single atomic update.
+type RateLimitServiceImpl struct {
+	ratelimitcollection *mongo.Collection
+}
+
+func NewRateLimitService(ratelimitcollection *mongo.Collection) RateLimitService {
+	return &RateLimitServiceImpl{
+		ratelimitcollection: ratelimitcollection,
+	}
+}
+
+func (r *RateLimitServiceImpl) Take(c context.Context, key string, rule models.RateLimitRule) (models.RateLimitResult, error) {
+	now := time.Now().Truncate(time.Millisecond)
+
+	var pipeline mongo.Pipeline
+	if rule.Algorithm == models.RateLimitTokenBucket {
+		rate := float64(rule.Limit) / rule.Window.Seconds()
+		elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}, 1000}}
+		pipeline = mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"tokens": bson.M{"$min": bson.A{
+					float64(rule.Limit),
+					bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", float64(rule.Limit)}}, bson.M{"$multiply": bson.A{elapsed, rate}}}},
+				}},
+			}}},
+			{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+			{{Key: "$set", Value: bson.M{
+				"tokens":     bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+				"updated_at": now,
+				"expires_at": now.Add(rule.Window),
+			}}},
+		}
+	} else {
+		windowStart := now.Truncate(rule.Window)
+		current := bson.M{"$eq": bson.A{"$window_start", windowStart}}
+		// both fields are computed from the counter as it was before this request
+		pipeline = mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"previous": bson.M{"$switch": bson.M{
+					"branches": bson.A{
+						bson.M{"case": current, "then": "$previous"},
+						bson.M{"case": bson.M{"$eq": bson.A{"$window_start", windowStart.Add(-rule.Window)}}, "then": "$count"},
+					},
+					"default": 0,
+				}},
+				"count":        bson.M{"$cond": bson.A{current, bson.M{"$add": bson.A{"$count", 1}}, 1}},
+				"window_start": windowStart,
+				"updated_at":   now,
+				"expires_at":   windowStart.Add(2 * rule.Window),
+			}}},
+		}
+	}
+
+	var counter models.RateLimitCounter
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := r.ratelimitcollection.FindOneAndUpdate(c, bson.M{"_id": key}, pipeline, opts).Decode(&counter)
+	if mongo.IsDuplicateKeyError(err) {
+		// another replica inserted the counter first
+		err = r.ratelimitcollection.FindOneAndUpdate(c, bson.M{"_id": key}, pipeline, opts).Decode(&counter)
+	}
+	if err != nil {
+		return models.RateLimitResult{}, err
+	}
+
+	if rule.Algorithm == models.RateLimitTokenBucket {
+		return tokenBucket(rule, counter.Tokens, counter.Allowed), nil
+	}
+	return slidingWindow(rule, now, counter.WindowStart, counter.Count, counter.Previous), nil
+}
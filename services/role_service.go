@@ -0,0 +1,16 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+)
+
+type RoleService interface {
+	SeedRoles(context.Context) error
+	CreateRole(context.Context, *models.Role) error
+	UpdateRole(context.Context, string, *models.Role) error
+	DeleteRole(context.Context, string) error
+	GetRoles(context.Context) ([]models.Role, error)
+	AssignRoles(context.Context, string, []string) error
+	PermissionsFor(context.Context, []string) ([]string, error)
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-auth/helpers"
+	"go-auth/models"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("role already exists")
+	ErrSystemRole   = errors.New("system roles cannot be deleted")
+)
+
+type RoleServiceImpl struct {
+	rolecollection *mongo.Collection
+	usercollection *mongo.Collection
+	tokenservice   TokenService
+}
+
+func NewRoleService(rolecollection *mongo.Collection, usercollection *mongo.Collection, tokenservice TokenService) RoleService {
+	return &RoleServiceImpl{
+		rolecollection: rolecollection,
+		usercollection: usercollection,
+		tokenservice:   tokenservice,
+	}
+}
+
+// SeedRoles creates the admin and user roles, keeps the admin role in sync
+// with the permissions this version knows, and gives users that predate
+// roles the role matching their user type. The user role is only seeded
+// when missing, so permissions granted to it with UpdateRole survive
+// restarts.
+func (r *RoleServiceImpl) SeedRoles(c context.Context) error {
+	now := time.Now()
+	admin := bson.M{
+		"$set": bson.M{
+			"description": "Full access to every administrative API",
+			"permissions": helpers.AllPermissions,
+			"system":      true,
+			"updated_at":  now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	user := bson.M{
+		"$set": bson.M{"system": true},
+		"$setOnInsert": bson.M{
+			"description": "Self-service access to the own account",
+			"permissions": []string{},
+			"created_at":  now,
+			"updated_at":  now,
+		},
+	}
+	seeds := []struct {
+		name   string
+		update bson.M
+	}{
+		{name: helpers.RoleAdmin, update: admin},
+		{name: helpers.RoleUser, update: user},
+	}
+	for _, seed := range seeds {
+		_, err := r.rolecollection.UpdateOne(c,
+			bson.M{"name": seed.name},
+			seed.update,
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := r.rolecollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.usercollection.UpdateMany(c,
+		bson.M{"roles": bson.M{"$exists": false}, "user_type": "ADMIN"},
+		bson.M{"$set": bson.M{"roles": []string{helpers.RoleAdmin}}},
+	); err != nil {
+		return err
+	}
+	_, err = r.usercollection.UpdateMany(c,
+		bson.M{"roles": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"roles": []string{helpers.RoleUser}}},
+	)
+	return err
+}
+
+func (r *RoleServiceImpl) CreateRole(c context.Context, role *models.Role) error {
+	if err := validatePermissions(role.Permissions); err != nil {
+		return err
+	}
+	role.ID = primitive.NewObjectID()
+	role.System = false
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+
+	_, err := r.rolecollection.InsertOne(c, role)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrRoleExists
+	}
+	return err
+}
+
+// UpdateRole replaces the description and permissions of a role. Tokens
+// already issued keep their permissions until they are refreshed.
+func (r *RoleServiceImpl) UpdateRole(c context.Context, name string, role *models.Role) error {
+	if err := validatePermissions(role.Permissions); err != nil {
+		return err
+	}
+	if name == helpers.RoleAdmin {
+		return errors.New("the admin role always has every permission")
+	}
+	result, err := r.rolecollection.UpdateOne(c,
+		bson.M{"name": name},
+		bson.M{"$set": bson.M{
+			"description": role.Description,
+			"permissions": role.Permissions,
+			"updated_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (r *RoleServiceImpl) DeleteRole(c context.Context, name string) error {
+	var role models.Role
+	if err := r.rolecollection.FindOne(c, bson.M{"name": name}).Decode(&role); err != nil {
+		return ErrRoleNotFound
+	}
+	if role.System {
+		return ErrSystemRole
+	}
+	if _, err := r.rolecollection.DeleteOne(c, bson.M{"_id": role.ID}); err != nil {
+		return err
+	}
+	_, err := r.usercollection.UpdateMany(c,
+		bson.M{"roles": name},
+		bson.M{"$pull": bson.M{"roles": name}},
+	)
+	return err
+}
+
+func (r *RoleServiceImpl) GetRoles(c context.Context) ([]models.Role, error) {
+	cursor, err := r.rolecollection.Find(c, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	roles := []models.Role{}
+	if err := cursor.All(c, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// AssignRoles replaces the roles of a user. The user is signed out
+// everywhere so that no token keeps permissions that were taken away.
+func (r *RoleServiceImpl) AssignRoles(c context.Context, userId string, roles []string) error {
+	count, err := r.rolecollection.CountDocuments(c, bson.M{"name": bson.M{"$in": roles}})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(roles) {
+		return ErrRoleNotFound
+	}
+
+	// user_type is kept for clients and lifetime settings that still read it
+	userType := "USER"
+	if slices.Contains(roles, helpers.RoleAdmin) {
+		userType = "ADMIN"
+	}
+	result, err := r.usercollection.UpdateOne(c,
+		bson.M{"user_id": userId},
+		bson.M{"$set": bson.M{"roles": roles, "user_type": userType, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return r.tokenservice.RevokeUserTokens(c, userId, "roles changed")
+}
+
+// PermissionsFor returns the union of the permissions granted by roles.
+func (r *RoleServiceImpl) PermissionsFor(c context.Context, roles []string) ([]string, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	cursor, err := r.rolecollection.Find(c, bson.M{"name": bson.M{"$in": roles}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Role
+	if err := cursor.All(c, &found); err != nil {
+		return nil, err
+	}
+
+	var permissions []string
+	for _, role := range found {
+		for _, permission := range role.Permissions {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !helpers.IsKnownPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+	return nil
+}
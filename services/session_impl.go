@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"go-auth/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// touchSession records a login session the first time a refresh token of
+// its family is issued and bumps its last use on every rotation.
+func (t *TokenServiceImpl) touchSession(c context.Context, record *models.RefreshToken) error {
+	_, err := t.sessioncollection.UpdateOne(c,
+		bson.M{"_id": record.FamilyID},
+		bson.M{
+			"$set": bson.M{
+				"device":       record.Device,
+				"last_used_at": record.CreatedAt,
+				"expires_at":   record.ExpiresAt,
+			},
+			"$setOnInsert": bson.M{
+				"user_id":    record.UserID,
+				"client_id":  record.ClientID,
+				"revoked":    false,
+				"created_at": record.CreatedAt,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// ListSessions returns the active sessions of a user, most recently used first.
+func (t *TokenServiceImpl) ListSessions(c context.Context, userId string) ([]models.Session, error) {
+	cursor, err := t.sessioncollection.Find(c,
+		bson.M{"user_id": userId, "revoked": false, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.M{"last_used_at": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	if err := cursor.All(c, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of a user: its refresh tokens stop working
+// and the access tokens already issued for it are denied.
+func (t *TokenServiceImpl) RevokeSession(c context.Context, userId string, sessionId string, reason string) error {
+	var session models.Session
+	err := t.sessioncollection.FindOne(c, bson.M{"_id": sessionId, "user_id": userId, "revoked": false}).Decode(&session)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	return t.revokeSession(c, &session, reason)
+}
+
+// RevokeOtherSessions ends every session of a user except the one given and
+// returns how many were ended.
+func (t *TokenServiceImpl) RevokeOtherSessions(c context.Context, userId string, keepSessionId string, reason string) (int, error) {
+	sessions, err := t.ListSessions(c, userId)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for i := range sessions {
+		if sessions[i].ID == keepSessionId {
+			continue
+		}
+		if err := t.revokeSession(c, &sessions[i], reason); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (t *TokenServiceImpl) revokeSession(c context.Context, session *models.Session, reason string) error {
+	if err := t.RevokeFamily(c, session.ID); err != nil {
+		return err
+	}
+
+	// no access token of the session outlives its refresh tokens
+	record := models.RevokedToken{
+		SessionID: session.ID,
+		Reason:    reason,
+		ExpiresAt: session.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if _, err := t.revokedcollection.InsertOne(c, record); err != nil {
+		return err
+	}
+	t.cache(record)
+	return nil
+}
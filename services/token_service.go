@@ -0,0 +1,32 @@
+package services
+
+import (
+	"context"
+	"go-auth/helpers"
+	"go-auth/models"
+	"time"
+)
+
+type TokenService interface {
+	SaveRefreshToken(context.Context, *models.RefreshToken, string) error
+	RotateRefreshToken(context.Context, string) (*models.RefreshToken, error)
+	GetRefreshToken(context.Context, string) (*models.RefreshToken, error)
+	RevokeRefreshToken(context.Context, string) error
+	RevokeFamily(context.Context, string) error
+
+	RevokeAccessToken(context.Context, string, time.Time, string) error
+	RevokeUserTokens(context.Context, string, string) error
+	IsRevoked(context.Context, *helpers.SignedDetails) (bool, error)
+
+	ListSessions(context.Context, string) ([]models.Session, error)
+	RevokeSession(context.Context, string, string, string) error
+	RevokeOtherSessions(context.Context, string, string, string) (int, error)
+
+	SaveAuthorizationCode(context.Context, *models.AuthorizationCode, string) error
+	ConsumeAuthorizationCode(context.Context, string) (*models.AuthorizationCode, error)
+
+	SaveDeviceCode(context.Context, *models.DeviceCode, string) error
+	GetDeviceCodeByUserCode(context.Context, string) (*models.DeviceCode, error)
+	DecideDeviceCode(context.Context, string, string, bool) error
+	PollDeviceCode(context.Context, string) (*models.DeviceCode, error)
+}
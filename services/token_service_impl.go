@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-auth/helpers"
+	"go-auth/models"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrInvalidGrant        = errors.New("authorization code is invalid or expired")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionExpired      = errors.New("session has expired, please log in again")
+)
+
+// revocationSyncInterval bounds how long a revocation made by another
+// replica can take to be seen by this one.
+const revocationSyncInterval = 10 * time.Second
+
+type TokenServiceImpl struct {
+	refreshcollection *mongo.Collection
+	revokedcollection *mongo.Collection
+	codecollection    *mongo.Collection
+	devicecollection  *mongo.Collection
+	sessioncollection *mongo.Collection
+
+	mu              sync.RWMutex
+	revokedJtis     map[string]time.Time
+	revokedSessions map[string]time.Time
+	revokedUsers    map[string]time.Time
+	lastSync        time.Time
+}
+
+func NewTokenService(refreshcollection *mongo.Collection, revokedcollection *mongo.Collection, codecollection *mongo.Collection, devicecollection *mongo.Collection, sessioncollection *mongo.Collection) TokenService {
+	return &TokenServiceImpl{
+		refreshcollection: refreshcollection,
+		revokedcollection: revokedcollection,
+		codecollection:    codecollection,
+		devicecollection:  devicecollection,
+		sessioncollection: sessioncollection,
+		revokedJtis:       map[string]time.Time{},
+		revokedSessions:   map[string]time.Time{},
+		revokedUsers:      map[string]time.Time{},
+	}
+}
+
+// SaveRefreshToken persists the hash of a newly issued refresh token.
+// The record must carry the family, parent, user, device and expiry.
+func (t *TokenServiceImpl) SaveRefreshToken(c context.Context, record *models.RefreshToken, refreshToken string) error {
+	record.TokenHash = helpers.HashToken(refreshToken)
+	record.CreatedAt = time.Now()
+	record.Used = false
+	record.Revoked = false
+
+	if _, err := t.refreshcollection.InsertOne(c, record); err != nil {
+		return err
+	}
+	return t.touchSession(c, record)
+}
+
+// RotateRefreshToken marks the presented token as used and returns its record
+// so a successor can be issued in the same family. Presenting a token that has
+// already been used revokes the whole family.
+func (t *TokenServiceImpl) RotateRefreshToken(c context.Context, refreshToken string) (*models.RefreshToken, error) {
+	hash := helpers.HashToken(refreshToken)
+	now := time.Now()
+
+	var record models.RefreshToken
+	err := t.refreshcollection.FindOneAndUpdate(c,
+		bson.M{"token_hash": hash, "used": false, "revoked": false},
+		bson.M{"$set": bson.M{"used": true, "used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&record)
+	if err == nil {
+		if now.After(record.ExpiresAt) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return &record, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// the token is unknown, already rotated or revoked
+	if err := t.refreshcollection.FindOne(c, bson.M{"token_hash": hash}).Decode(&record); err != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if record.Used {
+		if err := t.RevokeFamily(c, record.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	return nil, ErrRefreshTokenInvalid
+}
+
+// GetRefreshToken returns the record of a refresh token that can still be used.
+func (t *TokenServiceImpl) GetRefreshToken(c context.Context, refreshToken string) (*models.RefreshToken, error) {
+	var record models.RefreshToken
+	err := t.refreshcollection.FindOne(c, bson.M{
+		"token_hash": helpers.HashToken(refreshToken),
+		"used":       false,
+		"revoked":    false,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (t *TokenServiceImpl) RevokeRefreshToken(c context.Context, refreshToken string) error {
+	var record models.RefreshToken
+	err := t.refreshcollection.FindOne(c, bson.M{"token_hash": helpers.HashToken(refreshToken)}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	return t.RevokeFamily(c, record.FamilyID)
+}
+
+func (t *TokenServiceImpl) RevokeFamily(c context.Context, familyId string) error {
+	_, err := t.refreshcollection.UpdateMany(c,
+		bson.M{"family_id": familyId},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = t.sessioncollection.UpdateOne(c,
+		bson.M{"_id": familyId},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeAccessToken denies a single token until it would have expired anyway.
+func (t *TokenServiceImpl) RevokeAccessToken(c context.Context, jti string, expiresAt time.Time, reason string) error {
+	if jti == "" {
+		return errors.New("token has no jti")
+	}
+	record := models.RevokedToken{
+		Jti:       jti,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if _, err := t.revokedcollection.InsertOne(c, record); err != nil {
+		return err
+	}
+	t.cache(record)
+	return nil
+}
+
+// RevokeUserTokens denies every token issued to the user so far and revokes
+// all of their refresh token families.
+func (t *TokenServiceImpl) RevokeUserTokens(c context.Context, userId string, reason string) error {
+	now := time.Now()
+	record := models.RevokedToken{
+		UserID:       userId,
+		IssuedBefore: now,
+		Reason:       reason,
+		ExpiresAt:    now.Add(helpers.MaxTokenLifetime()),
+		CreatedAt:    now,
+	}
+	if _, err := t.revokedcollection.InsertOne(c, record); err != nil {
+		return err
+	}
+	t.cache(record)
+
+	_, err := t.refreshcollection.UpdateMany(c,
+		bson.M{"user_id": userId},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = t.sessioncollection.UpdateMany(c,
+		bson.M{"user_id": userId, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_at": now}},
+	)
+	return err
+}
+
+func (t *TokenServiceImpl) IsRevoked(c context.Context, claims *helpers.SignedDetails) (bool, error) {
+	if err := t.syncRevocations(c); err != nil {
+		return false, err
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if _, ok := t.revokedJtis[claims.ID]; ok && claims.ID != "" {
+		return true, nil
+	}
+	if _, ok := t.revokedSessions[claims.SessionID]; ok && claims.SessionID != "" {
+		return true, nil
+	}
+	subjects := []string{claims.Subject}
+	if claims.Act != nil {
+		// revoking the actor also ends the sessions they are impersonating
+		subjects = append(subjects, claims.Act.Subject)
+	}
+	for _, subject := range subjects {
+		if issuedBefore, ok := t.revokedUsers[subject]; ok {
+			// the cutoff is stored with millisecond precision, so a token
+			// issued within the millisecond of the revocation is revoked too
+			if claims.IssuedAtMillis() <= issuedBefore.UnixMilli() {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// syncRevocations pulls revocations created since the last sync into the
+// in-process cache and evicts the ones that have expired.
+func (t *TokenServiceImpl) syncRevocations(c context.Context) error {
+	t.mu.RLock()
+	since := t.lastSync
+	t.mu.RUnlock()
+	if time.Since(since) < revocationSyncInterval {
+		return nil
+	}
+
+	now := time.Now()
+	cursor, err := t.revokedcollection.Find(c, bson.M{
+		"created_at": bson.M{"$gte": since.Add(-revocationSyncInterval)},
+		"expires_at": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return err
+	}
+	var records []models.RevokedToken
+	if err := cursor.All(c, &records); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for jti, expiresAt := range t.revokedJtis {
+		if now.After(expiresAt) {
+			delete(t.revokedJtis, jti)
+		}
+	}
+	for sessionId, expiresAt := range t.revokedSessions {
+		if now.After(expiresAt) {
+			delete(t.revokedSessions, sessionId)
+		}
+	}
+	for userId, issuedBefore := range t.revokedUsers {
+		if now.Sub(issuedBefore) > helpers.MaxTokenLifetime() {
+			delete(t.revokedUsers, userId)
+		}
+	}
+	for _, record := range records {
+		t.cacheLocked(record)
+	}
+	t.lastSync = now
+	return nil
+}
+
+func (t *TokenServiceImpl) cache(record models.RevokedToken) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cacheLocked(record)
+}
+
+func (t *TokenServiceImpl) cacheLocked(record models.RevokedToken) {
+	if record.Jti != "" {
+		t.revokedJtis[record.Jti] = record.ExpiresAt
+	}
+	if record.SessionID != "" {
+		t.revokedSessions[record.SessionID] = record.ExpiresAt
+	}
+	if record.UserID != "" && record.IssuedBefore.After(t.revokedUsers[record.UserID]) {
+		t.revokedUsers[record.UserID] = record.IssuedBefore
+	}
+}
+
+func (t *TokenServiceImpl) SaveAuthorizationCode(c context.Context, record *models.AuthorizationCode, code string) error {
+	record.CodeHash = helpers.HashToken(code)
+	record.Used = false
+	record.CreatedAt = time.Now()
+
+	_, err := t.codecollection.InsertOne(c, record)
+	return err
+}
+
+// ConsumeAuthorizationCode redeems a code exactly once and assigns the
+// refresh token family the tokens for it are issued in. A code presented
+// again revokes that family and its access tokens (RFC 6749 section 4.1.2),
+// since the code has evidently leaked.
+func (t *TokenServiceImpl) ConsumeAuthorizationCode(c context.Context, code string) (*models.AuthorizationCode, error) {
+	hash := helpers.HashToken(code)
+	var record models.AuthorizationCode
+	err := t.codecollection.FindOneAndUpdate(c,
+		bson.M{"code_hash": hash, "used": false},
+		bson.M{"$set": bson.M{"used": true, "family_id": primitive.NewObjectID().Hex()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		if err := t.codecollection.FindOne(c, bson.M{"code_hash": hash, "used": true}).Decode(&record); err != nil {
+			return nil, ErrInvalidGrant
+		}
+		err := t.RevokeSession(c, record.UserID, record.FamilyID, "authorization code reused")
+		if err != nil && err != ErrSessionNotFound {
+			return nil, err
+		}
+		log.Printf("audit: authorization code reused, session revoked user=%s client=%s", record.UserID, record.ClientID)
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+	return &record, nil
+}
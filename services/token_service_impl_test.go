@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"go-auth/helpers"
+	"go-auth/models"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestTokenService returns a token service whose revocation cache is
+// fresh, so IsRevoked does not reach for the collections.
+func newTestTokenService() *TokenServiceImpl {
+	service := NewTokenService(nil, nil, nil, nil, nil).(*TokenServiceImpl)
+	service.lastSync = time.Now()
+	return service
+}
+
+func issuedAt(t time.Time) *helpers.SignedDetails {
+	return &helpers.SignedDetails{
+		IssuedAtMs: t.UnixMilli(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  "user-1",
+			IssuedAt: jwt.NewNumericDate(t),
+		},
+	}
+}
+
+func TestIsRevokedUserCutoff(t *testing.T) {
+	revokedAt := time.Unix(1700000000, 600*int64(time.Millisecond))
+	service := newTestTokenService()
+	service.cache(models.RevokedToken{UserID: "user-1", IssuedBefore: revokedAt})
+
+	legacy := issuedAt(revokedAt.Add(time.Second))
+	legacy.IssuedAtMs = 0
+	sameSecondLegacy := issuedAt(revokedAt.Add(200 * time.Millisecond))
+	sameSecondLegacy.IssuedAtMs = 0
+
+	tests := []struct {
+		name   string
+		claims *helpers.SignedDetails
+		want   bool
+	}{
+		{name: "earlier in the same second", claims: issuedAt(revokedAt.Add(-100 * time.Millisecond)), want: true},
+		{name: "same millisecond", claims: issuedAt(revokedAt), want: true},
+		{name: "later in the same second", claims: issuedAt(revokedAt.Add(100 * time.Millisecond)), want: false},
+		{name: "previous second", claims: issuedAt(revokedAt.Add(-time.Second)), want: true},
+		{name: "no iat_ms in the same second", claims: sameSecondLegacy, want: true},
+		{name: "no iat_ms in a later second", claims: legacy, want: false},
+		{name: "no iat", claims: &helpers.SignedDetails{RegisteredClaims: jwt.RegisteredClaims{Subject: "user-1"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := service.IsRevoked(context.Background(), tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked != tt.want {
+				t.Errorf("IsRevoked = %v, want %v", revoked, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRevokedActor(t *testing.T) {
+	revokedAt := time.Now()
+	service := newTestTokenService()
+	service.cache(models.RevokedToken{UserID: "admin-1", IssuedBefore: revokedAt})
+
+	claims := issuedAt(revokedAt.Add(-time.Millisecond))
+	claims.Act = &helpers.Actor{Subject: "admin-1"}
+	revoked, err := service.IsRevoked(context.Background(), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Error("impersonation token of a revoked actor is not revoked")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"go-auth/helpers"
 	"go-auth/models"
+	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,15 +13,34 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrInvalidLoginClient is returned when a login names a client that is not
+// a registered public client. Confidential clients sign users in through
+// the authorization code flow, where they authenticate.
+var ErrInvalidLoginClient = errors.New("client_id is not a registered public client")
+
+// ErrPasswordChangeRequired is returned instead of tokens for a user whose
+// password turned up in a breach. Whoever knows the password may be an
+// attacker, so it has to be replaced through the email verified reset flow
+// before any grant succeeds again.
+var ErrPasswordChangeRequired = errors.New("password has appeared in a data breach and must be reset with /v1/forgotpassword")
+
 type UserServiceImpl struct {
 	usercollection *mongo.Collection
 	otpcollection  *mongo.Collection
+	tokenservice   TokenService
+	roleservice    RoleService
+	lockoutservice LockoutService
+	clientservice  ClientService
 }
 
-func NewUserService(usercollection *mongo.Collection, otpcollection *mongo.Collection) UserService {
+func NewUserService(usercollection *mongo.Collection, otpcollection *mongo.Collection, tokenservice TokenService, roleservice RoleService, lockoutservice LockoutService, clientservice ClientService) UserService {
 	return &UserServiceImpl{
 		usercollection: usercollection,
 		otpcollection:  otpcollection,
+		tokenservice:   tokenservice,
+		roleservice:    roleservice,
+		lockoutservice: lockoutservice,
+		clientservice:  clientservice,
 	}
 }
 
@@ -30,7 +50,13 @@ type PaginatedUsers struct {
 }
 
 func (u *UserServiceImpl) Signup(c context.Context, user *models.User) error {
-	password := helpers.HashPassword(*user.Password)
+	if err := helpers.ValidatePassword(*user.Password, *user.Username, *user.Email); err != nil {
+		return err
+	}
+	password, err := helpers.HashPassword(*user.Password)
+	if err != nil {
+		return err
+	}
 	user.Password = &password
 
 	// check for existing email
@@ -42,10 +68,7 @@ func (u *UserServiceImpl) Signup(c context.Context, user *models.User) error {
 		return errors.New("email already exists")
 	}
 
-	user.ID = primitive.NewObjectID()
-	user.User_id = user.ID.Hex()
-	user.Created_at = time.Now()
-	user.Updated_at = time.Now()
+	newSignupUser(user)
 
 	_, insertErr := u.usercollection.InsertOne(c, user)
 	if insertErr != nil {
@@ -54,30 +77,222 @@ func (u *UserServiceImpl) Signup(c context.Context, user *models.User) error {
 	return nil
 }
 
-func (u *UserServiceImpl) Login(c context.Context, email *string, password *string) (string, string, *models.User, error) {
-	var foundUser models.User
-	if err := u.usercollection.FindOne(c, bson.M{"email": email}).Decode(&foundUser); err != nil {
-		return "", "", nil, errors.New("email is not found")
+// newSignupUser resets everything on a user bound from a signup body that
+// the user may not choose for themselves.
+func newSignupUser(user *models.User) {
+	// privileges are only ever granted through the role APIs, and
+	// attributes only through SetAttributes
+	userType := "USER"
+	user.User_type = &userType
+	user.Roles = []string{helpers.RoleUser}
+	user.Attributes = nil
+	user.PasswordChangeRequired = false
+
+	user.ID = primitive.NewObjectID()
+	user.User_id = user.ID.Hex()
+	user.Email_verified = false
+	user.Created_at = time.Now()
+	user.Updated_at = time.Now()
+}
+
+func (u *UserServiceImpl) Login(c context.Context, req *models.LoginRequest) (*models.Tokens, *models.User, error) {
+	// every login starts a new refresh token family
+	grant := models.Grant{
+		Scope:    helpers.FilterScope(req.Scope, helpers.SupportedUserScopes),
+		Nonce:    req.Nonce,
+		FamilyID: primitive.NewObjectID().Hex(),
+		Device:   req.Device,
+		JKT:      req.JKT,
+	}
+	// the client picks the audience of the tokens, so it must be known
+	if req.ClientID != "" {
+		client, err := u.clientservice.GetClient(c, req.ClientID)
+		if err != nil || !client.Public {
+			return nil, nil, ErrInvalidLoginClient
+		}
+		grant.ClientID = client.ClientID
+		grant.Lifetimes = client.Lifetimes
+	}
+
+	foundUser, err := u.CheckCredentials(c, req.Email, req.Password, req.IP)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	grant.AuthTime = time.Now()
+	tokens, err := u.IssueTokens(c, foundUser, grant)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if foundUser.Email == nil {
-		return "", "", nil, errors.New("user not found")
+	foundUser.Password = nil
+
+	return tokens, foundUser, nil
+}
+
+// CheckCredentials returns the user if the email and password match.
+// Failures count towards locking the account and the client address ip;
+// while either is locked a LockoutError is returned without checking the
+// password.
+func (u *UserServiceImpl) CheckCredentials(c context.Context, email *string, password *string, ip string) (*models.User, error) {
+	var foundUser models.User
+	findErr := u.usercollection.FindOne(c, bson.M{"email": email}).Decode(&foundUser)
+
+	if err := u.lockoutservice.Check(c, foundUser.User_id, ip); err != nil {
+		return nil, err
+	}
+	if findErr != nil || foundUser.Email == nil {
+		if err := u.lockoutservice.RecordFailure(c, "", ip); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("email is not found")
 	}
+
 	passwordIsValid, err := helpers.VerifyPassword(*password, *foundUser.Password)
 	if !passwordIsValid {
-		return "", "", nil, err
+		if lockErr := u.lockoutservice.RecordFailure(c, foundUser.User_id, ip); lockErr != nil {
+			return nil, lockErr
+		}
+		return nil, err
+	}
+	if err := u.lockoutservice.RecordSuccess(c, foundUser.User_id); err != nil {
+		return nil, err
+	}
+	if helpers.PasswordNeedsRehash(*foundUser.Password) {
+		u.rehashPassword(c, &foundUser, *password)
 	}
+	if !foundUser.PasswordChangeRequired && helpers.IsPasswordBreached(*password) {
+		u.requirePasswordChange(c, &foundUser)
+	}
+	if foundUser.PasswordChangeRequired {
+		return nil, ErrPasswordChangeRequired
+	}
+	return &foundUser, nil
+}
 
-	token, refreshToken, _ := helpers.GenerateAllTokens(
-		*foundUser.Email,
-		*foundUser.Username,
-		*foundUser.User_type,
-		foundUser.User_id,
+// requirePasswordChange flags an account whose password turned up in a
+// breach after it was set. The login is refused even if the flag cannot be
+// stored; the password is checked against the filter again next time.
+func (u *UserServiceImpl) requirePasswordChange(c context.Context, user *models.User) {
+	user.PasswordChangeRequired = true
+	_, err := u.usercollection.UpdateOne(c,
+		bson.M{"user_id": user.User_id},
+		bson.M{"$set": bson.M{"password_change_required": true}},
 	)
+	if err != nil {
+		log.Println("error flagging breached password:", err)
+		return
+	}
+	log.Printf("audit: breached password detected at login user=%s", user.User_id)
+}
 
-	foundUser.Password = nil
+// rehashPassword replaces a hash made with outdated settings while the
+// plain password is at hand. Failing to do so does not fail the login.
+func (u *UserServiceImpl) rehashPassword(c context.Context, user *models.User, password string) {
+	hashedPassword, err := helpers.HashPassword(password)
+	if err != nil {
+		log.Println("error rehashing password:", err)
+		return
+	}
+	// the filter keeps a password changed in the meantime
+	_, err = u.usercollection.UpdateOne(c,
+		bson.M{"user_id": user.User_id, "password": *user.Password},
+		bson.M{"$set": bson.M{"password": hashedPassword}},
+	)
+	if err != nil {
+		log.Println("error rehashing password:", err)
+		return
+	}
+	user.Password = &hashedPassword
+}
+
+// IssueTokens signs an access and refresh token pair, plus an id_token when
+// the openid scope was granted, and stores the refresh token in its family.
+// Users who must reset a breached password get ErrPasswordChangeRequired,
+// so that codes, device approvals and refresh tokens obtained before the
+// breach was noticed are not redeemed either.
+func (u *UserServiceImpl) IssueTokens(c context.Context, user *models.User, grant models.Grant) (*models.Tokens, error) {
+	if user.PasswordChangeRequired {
+		return nil, ErrPasswordChangeRequired
+	}
+	lifetimes := helpers.ResolveLifetimes(*user.User_type, grant.Lifetimes)
+	// a rotated refresh token never outlives the absolute session timeout
+	if lifetimes.Absolute > 0 {
+		if remaining := time.Until(grant.AuthTime.Add(lifetimes.Absolute)); remaining < lifetimes.Refresh {
+			lifetimes.Refresh = remaining
+		}
+	}
+	if lifetimes.Refresh <= 0 {
+		return nil, ErrSessionExpired
+	}
+	permissions, err := u.roleservice.PermissionsFor(c, user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	token, refreshToken, err := helpers.GenerateAllTokens(helpers.TokenParams{
+		Email:       *user.Email,
+		Username:    *user.Username,
+		UserType:    *user.User_type,
+		Uid:         user.User_id,
+		ClientID:    grant.ClientID,
+		Scope:       grant.Scope,
+		JKT:         grant.JKT,
+		Roles:       user.Roles,
+		Permissions: permissions,
+		Attributes:  user.Attributes,
+		// the refresh token family doubles as the session id
+		SessionID: grant.FamilyID,
+		Lifetimes: lifetimes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &models.Tokens{
+		TokenType:        "Bearer",
+		AccessToken:      token,
+		RefreshToken:     refreshToken,
+		Scope:            grant.Scope,
+		ExpiresIn:        int(lifetimes.Access.Seconds()),
+		RefreshExpiresIn: int(lifetimes.Refresh.Seconds()),
+	}
+	if grant.JKT != "" {
+		tokens.TokenType = "DPoP"
+	}
+	if helpers.HasScope(grant.Scope, "openid") {
+		tokens.IDToken, err = helpers.GenerateIDToken(helpers.IDTokenParams{
+			Uid:           user.User_id,
+			Email:         *user.Email,
+			EmailVerified: user.Email_verified,
+			Username:      *user.Username,
+			ClientID:      grant.ClientID,
+			Scope:         grant.Scope,
+			Nonce:         grant.Nonce,
+			AuthTime:      grant.AuthTime,
+			Lifetime:      lifetimes.Access,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return token, refreshToken, &foundUser, nil
+	record := &models.RefreshToken{
+		FamilyID:  grant.FamilyID,
+		ParentID:  grant.ParentID,
+		UserID:    user.User_id,
+		ClientID:  grant.ClientID,
+		Scope:     grant.Scope,
+		AuthTime:  grant.AuthTime,
+		JKT:       grant.JKT,
+		Device:    grant.Device,
+		Lifetimes: grant.Lifetimes,
+		ExpiresAt: time.Now().Add(lifetimes.Refresh),
+	}
+	if err := u.tokenservice.SaveRefreshToken(c, record, refreshToken); err != nil {
+		return nil, err
+	}
+	return tokens, nil
 }
 
 func (u *UserServiceImpl) GetAll(c context.Context, page, recordPerPage, startIndex int) ([]*models.User, error) {
@@ -146,32 +361,120 @@ func (u *UserServiceImpl) DeleteUser(c context.Context, userId string) error {
 	if result.DeletedCount != 1 {
 		return errors.New("no matched document found for update")
 	}
-	return nil
+	return u.tokenservice.RevokeUserTokens(c, userId, "user deleted")
 }
 
-func (u *UserServiceImpl) Refresh(c context.Context, refreshToken string) (string, string, error) {
+// SetAttributes replaces the ABAC attributes of a user. They are embedded
+// in access tokens, so the user is signed out everywhere to have policies
+// see the new values.
+func (u *UserServiceImpl) SetAttributes(c context.Context, userId string, attributes map[string]string) error {
+	result, err := u.usercollection.UpdateOne(c,
+		bson.M{"user_id": userId},
+		bson.M{"$set": bson.M{"attributes": attributes, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return u.tokenservice.RevokeUserTokens(c, userId, "attributes changed")
+}
+
+// Refresh rotates a refresh token. client is the client that authenticated
+// at the token endpoint, or nil. A refresh token bound to a DPoP key can
+// only be used with a proof from the same key and a token issued to a
+// client only by that client, which is checked before the token is spent.
+// A session that has been idle or alive for longer than its timeouts allow
+// is ended instead.
+func (u *UserServiceImpl) Refresh(c context.Context, refreshToken string, client *models.Client, jkt string, device models.Device) (*models.Tokens, error) {
 	claims, msg := helpers.ValidateToken(refreshToken)
-	if msg != "" {
-		return "", "", errors.New("error while validating token")
+	if msg != "" || claims.TokenType != "refresh" {
+		return nil, errors.New("error while validating token")
 	}
 
 	var user models.User
-	err := u.usercollection.FindOne(c, bson.M{"user_id": claims.Uid}).Decode(&user)
+	err := u.usercollection.FindOne(c, bson.M{"user_id": claims.Subject}).Decode(&user)
 	if err != nil {
-		return "", "", errors.New("user not found")
+		return nil, errors.New("user not found")
 	}
 
-	NewAccess, NewRefresh, err := helpers.GenerateAllTokens(
-		*user.Email,
-		*user.Username,
-		*user.User_type,
-		user.User_id,
-	)
+	current, err := u.tokenservice.GetRefreshToken(c, refreshToken)
+	if err == nil {
+		if err := u.checkRefreshClient(c, current, client); err != nil {
+			return nil, err
+		}
+		if current.JKT != "" && current.JKT != jkt {
+			return nil, errors.New("refresh token is bound to another DPoP key")
+		}
+		if sessionTimedOut(current, helpers.ResolveLifetimes(*user.User_type, current.Lifetimes)) {
+			if err := u.tokenservice.RevokeFamily(c, current.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, ErrSessionExpired
+		}
+	}
+
+	previous, err := u.tokenservice.RotateRefreshToken(c, refreshToken)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	if previous.UserID != claims.Subject {
+		return nil, ErrRefreshTokenInvalid
 	}
+	if err := u.checkRefreshClient(c, previous, client); err != nil {
+		return nil, err
+	}
+
+	return u.IssueTokens(c, &user, models.Grant{
+		ClientID:  previous.ClientID,
+		Scope:     previous.Scope,
+		AuthTime:  previous.AuthTime,
+		FamilyID:  previous.FamilyID,
+		ParentID:  previous.ID.Hex(),
+		Device:    device,
+		JKT:       boundKey(previous.JKT, jkt),
+		Lifetimes: previous.Lifetimes,
+	})
+}
 
-	return NewAccess, NewRefresh, err
+// checkRefreshClient makes sure that a refresh token issued to a client is
+// redeemed by it. Without an authenticated client only tokens of public
+// clients are accepted, which have no secret to present.
+func (u *UserServiceImpl) checkRefreshClient(c context.Context, record *models.RefreshToken, client *models.Client) error {
+	if client != nil {
+		if record.ClientID != client.ClientID {
+			return ErrRefreshTokenInvalid
+		}
+		return nil
+	}
+	if record.ClientID == "" {
+		return nil
+	}
+	registered, err := u.clientservice.GetClient(c, record.ClientID)
+	if err != nil || !registered.Public {
+		return ErrRefreshTokenInvalid
+	}
+	return nil
+}
+
+// sessionTimedOut reports whether the session of a refresh token has been
+// idle since its last rotation, or alive since sign in, for too long.
+func sessionTimedOut(record *models.RefreshToken, lifetimes helpers.Lifetimes) bool {
+	now := time.Now()
+	if lifetimes.Idle > 0 && now.Sub(record.CreatedAt) > lifetimes.Idle {
+		return true
+	}
+	return lifetimes.Absolute > 0 && now.Sub(record.AuthTime) > lifetimes.Absolute
+}
+
+// boundKey keeps a family bound to its original DPoP key and binds an
+// unbound family to the key of the first proof presented with it.
+func boundKey(previous string, presented string) string {
+	if previous != "" {
+		return previous
+	}
+	return presented
 }
 
 func (u *UserServiceImpl) EmailExists(c context.Context, email string) (bool, error) {
@@ -222,17 +525,83 @@ func (u *UserServiceImpl) VerifyOTP(c context.Context, email string, otp string)
 		return err
 	}
 
-	return nil
+	// receiving the OTP proves control of the mailbox
+	_, err = u.usercollection.UpdateOne(c,
+		bson.M{"email": email},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	return err
 }
 
 func (u *UserServiceImpl) ResetPassword(c context.Context, email string, password string) error {
-	hashedPassword := helpers.HashPassword(password) // implement bcrypt
+	var user models.User
+	if err := u.usercollection.FindOne(c, bson.M{"email": email}).Decode(&user); err != nil {
+		return errors.New("user not found")
+	}
+	if err := helpers.ValidatePassword(password, *user.Username, *user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := helpers.HashPassword(password)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"email": email}
-	update := bson.M{"$set": bson.M{"password": hashedPassword}}
+	update := bson.M{"$set": bson.M{"password": hashedPassword, "password_change_required": false}}
 
-	_, err := u.usercollection.UpdateOne(c, filter, update)
+	_, err = u.usercollection.UpdateOne(c, filter, update)
 	if err != nil {
 		return err
 	}
-	return nil
+	return u.tokenservice.RevokeUserTokens(c, user.User_id, "password reset")
+}
+
+// ChangePassword replaces the password of a signed in user who knows the
+// current one. Every other session of the user is signed out. Wrong current
+// passwords count towards the lockout like failed logins, so that a stolen
+// session cannot be used to guess the password.
+func (u *UserServiceImpl) ChangePassword(c context.Context, userId string, sessionId string, ip string, currentPassword string, newPassword string) error {
+	var user models.User
+	if err := u.usercollection.FindOne(c, bson.M{"user_id": userId}).Decode(&user); err != nil {
+		return errors.New("user not found")
+	}
+	if err := u.lockoutservice.Check(c, userId, ip); err != nil {
+		return err
+	}
+	if _, err := helpers.VerifyPassword(currentPassword, *user.Password); err != nil {
+		if errors.Is(err, helpers.ErrPasswordIncorrect) {
+			if lockErr := u.lockoutservice.RecordFailure(c, userId, ip); lockErr != nil {
+				return lockErr
+			}
+		}
+		return err
+	}
+	if err := u.lockoutservice.RecordSuccess(c, userId); err != nil {
+		return err
+	}
+	if newPassword == currentPassword {
+		policyErr := &helpers.PasswordPolicyError{}
+		errors.As(helpers.ValidatePassword(newPassword, *user.Username, *user.Email), &policyErr)
+		policyErr.Violations = append(policyErr.Violations, helpers.PasswordViolation{
+			Rule: "reused", Message: "must differ from the current password",
+		})
+		return policyErr
+	}
+	if err := helpers.ValidatePassword(newPassword, *user.Username, *user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := helpers.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	_, err = u.usercollection.UpdateOne(c,
+		bson.M{"user_id": userId},
+		bson.M{"$set": bson.M{"password": hashedPassword, "password_change_required": false, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = u.tokenservice.RevokeOtherSessions(c, userId, sessionId, "password changed")
+	return err
 }
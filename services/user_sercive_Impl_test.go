@@ -0,0 +1,41 @@
+package services
+
+import (
+	"encoding/json"
+	"go-auth/helpers"
+	"go-auth/models"
+	"slices"
+	"testing"
+)
+
+func TestNewSignupUserDropsPrivileges(t *testing.T) {
+	body := `{
+		"username": "mallory",
+		"email": "mallory@example.com",
+		"password": "a long enough password",
+		"user_type": "ADMIN",
+		"user_id": "admin",
+		"email_verified": true,
+		"roles": ["admin"],
+		"attributes": {"department": "finance", "clearance": "top"}
+	}`
+	var user models.User
+	if err := json.Unmarshal([]byte(body), &user); err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Attributes) == 0 {
+		t.Fatal("attributes were not bound from the body")
+	}
+
+	newSignupUser(&user)
+
+	if user.Attributes != nil {
+		t.Errorf("attributes = %v, want none", user.Attributes)
+	}
+	if *user.User_type != "USER" || !slices.Equal(user.Roles, []string{helpers.RoleUser}) {
+		t.Errorf("user_type = %s, roles = %v, want USER with the user role", *user.User_type, user.Roles)
+	}
+	if user.User_id != user.ID.Hex() || user.Email_verified {
+		t.Errorf("user_id = %s, email_verified = %v, want a new id and unverified", user.User_id, user.Email_verified)
+	}
+}
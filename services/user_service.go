@@ -8,17 +8,21 @@ import (
 type UserService interface {
 	Signup(context.Context, *models.User) error
 	EmailExists(context.Context, string) (bool, error)
-	Login(context.Context, *string, *string) (string, string, *models.User, error)
+	Login(context.Context, *models.LoginRequest) (*models.Tokens, *models.User, error)
+	CheckCredentials(context.Context, *string, *string, string) (*models.User, error)
+	IssueTokens(context.Context, *models.User, models.Grant) (*models.Tokens, error)
 
 	SaveOTP(context.Context, string, string) error
 	VerifyOTP(context.Context, string, string) error
 	ResetPassword(context.Context, string, string) error
+	ChangePassword(context.Context, string, string, string, string, string) error
 
-	Refresh(context.Context, string) (string, string, error)
+	Refresh(context.Context, string, *models.Client, string, models.Device) (*models.Tokens, error)
 
 	GetUser(context.Context, *string) (*models.User, error)
 	GetAll(context.Context, int, int, int) ([]*models.User, error)
 
 	UpdateUser(context.Context, *models.User) error
 	DeleteUser(context.Context, string) error
+	SetAttributes(context.Context, string, map[string]string) error
 }